@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,13 +16,38 @@ import (
 )
 
 type config struct {
+	subcmd string
+
 	token, sideOps, fictOps, period, format, acc string
 
+	benchmark string
+	riskFree  float64
+	replay    string
+
+	targetsFile  string
+	strategyFile string
+	tariffsFile  string
+	inputFile    string
+	mappingFile  string
+	ledgerFile   string
+	overrides    string
+	tagsFile     string
+
+	types           []string
+	currency        string
+	minAmount       float64
+	groupBy, sortBy string
+	offline         bool
+	cash, fee       float64
+	isBuyOnly       bool
+
+	costBasis, lotsFile, sectionsFile, benchmarksFile string
+
 	tickers []string
 
 	start, end, at time.Time
 
-	startSet bool
+	startSet, strict bool
 }
 
 func parseDate(s string, def time.Time) (time.Time, bool) {
@@ -55,7 +81,18 @@ func parseCmdline() (string, config) {
 		"show",
 		"story",
 		"deals",
+		"trades",
 		"price",
+		"risk",
+		"analyze",
+		"rebalance",
+		"backtest",
+		"dividends",
+		"unrealized",
+		"fees",
+		"iis",
+		"import",
+		"sync",
 	)
 
 	if !cmds.Has(cmd) {
@@ -63,6 +100,22 @@ func parseCmdline() (string, config) {
 		log.Fatalf("unknown command %s", cmd)
 	}
 
+	args := os.Args[2:]
+
+	if cmd == "analyze" {
+		subcmds := aux.NewList(
+			"correlation",
+		)
+
+		if len(args) < 1 || !subcmds.Has(args[0]) {
+			usage()
+			log.Fatal("unknown analyze subcommand")
+		}
+
+		cfg.subcmd = args[0]
+		args = args[1:]
+	}
+
 	// ------------
 	// List options
 
@@ -79,12 +132,62 @@ func parseCmdline() (string, config) {
 	atTime := fs.String("at", "", "point in time (default: now). Not supported yet")
 	format := fs.String("format", "human", "output format")
 	tickers := fs.String("tickers", "", "list of tickers")
-
-	fs.Parse(os.Args[2:])
+	costBasis := fs.String("cost-basis", "average", "lot matching method")
+	lotsFile := fs.String("lots", "", "json file with lots picked for sells")
+	strict := fs.Bool("strict", false, "fail on unknown operation types")
+	sectionsFile := fs.String("sections", "", "json file with custom sections")
+	benchmarksFile := fs.String("benchmarks", "", "json file with custom benchmarks")
+	targetsFile := fs.String("targets", "", "json file with target weights")
+	strategyFile := fs.String("strategy", "", "json file with the strategy to backtest")
+	tariffsFile := fs.String("tariffs", "", "json file with tariffs to compare fees with")
+	inputFile := fs.String("input", "", "csv or xlsx statement to import")
+	mappingFile := fs.String("mapping", "", "json file with the statement columns")
+	ledgerFile := fs.String("ledger", "", "json lines file to keep the operations in")
+	offline := fs.Bool("offline", false, "do not sync the ledger")
+	overrides := fs.String("overrides", "", "json file with operation corrections")
+	tagsFile := fs.String("tags", "", "json file with strategy tags")
+	types := fs.String("types", "", "list of operation types")
+	currency := fs.String("currency", "", "currency")
+	minAmount := fs.Float64("min-amount", 0, "minimal payment")
+	groupBy := fs.String("group-by", "", "ticker|type|month")
+	sortBy := fs.String("sort", "date", "date|amount|ticker|yield|days")
+	cash := fs.Float64("cash", 0, "new cash to invest, RUB")
+	fee := fs.Float64("fee", 0.3, "broker commission, %")
+	isBuyOnly := fs.Bool("buy-only", false, "only buy with the new cash")
+	benchmark := fs.String("benchmark", "", "benchmark ticker or mix")
+	replay := fs.String("replay", "", "benchmark ticker or mix to replay the payins into")
+	riskFree := fs.Float64("riskfree", 0, "annual risk-free rate, %")
+
+	fs.Parse(args)
 
 	cfg.token = *token
 	cfg.sideOps = *sideOps
 	cfg.fictOps = *fictOps
+	cfg.strict = *strict
+	cfg.sectionsFile = *sectionsFile
+	cfg.benchmarksFile = *benchmarksFile
+	cfg.targetsFile = *targetsFile
+	cfg.strategyFile = *strategyFile
+	cfg.tariffsFile = *tariffsFile
+	cfg.inputFile = *inputFile
+	cfg.mappingFile = *mappingFile
+	cfg.ledgerFile = *ledgerFile
+	cfg.offline = *offline
+	cfg.overrides = *overrides
+	cfg.tagsFile = *tagsFile
+	if *types != "" {
+		cfg.types = strings.Split(*types, ",")
+	}
+	cfg.currency = *currency
+	cfg.minAmount = *minAmount
+	cfg.groupBy = *groupBy
+	cfg.sortBy = *sortBy
+	cfg.cash = *cash
+	cfg.fee = *fee / 100
+	cfg.isBuyOnly = *isBuyOnly
+	cfg.benchmark = *benchmark
+	cfg.replay = *replay
+	cfg.riskFree = *riskFree / 100
 	if *tickers != "" {
 		cfg.tickers = strings.Split(*tickers, ",")
 	}
@@ -109,10 +212,23 @@ func parseCmdline() (string, config) {
 	formats := aux.NewList(
 		"human",
 		"table",
+		"json",
 	)
 	if !formats.Has(*format) {
 		log.Fatalf("bad format %s", *format)
 	}
+
+	jsonCmds := aux.NewList(
+		"deals",
+		"trades",
+		"analyze",
+		"dividends",
+		"unrealized",
+		"fees",
+	)
+	if *format == "json" && !jsonCmds.Has(cmd) {
+		log.Fatalf("no json format for %s", cmd)
+	}
 	cfg.format = *format
 
 	// --------------
@@ -123,11 +239,20 @@ func parseCmdline() (string, config) {
 		"iis",
 		"all",
 	)
-	if !accs.Has(*acc) {
+	if !accs.Has(*acc) && !isAccountId(*acc) {
 		log.Fatalf("bad account type %s", *acc)
 	}
 	cfg.acc = *acc
 
+	if cmd == "iis" {
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "account" && *acc != "iis" {
+				log.Fatalf("iis is for the iis accounts only, not %s", *acc)
+			}
+		})
+		cfg.acc = "iis"
+	}
+
 	// --------------
 	// Verify period
 
@@ -143,6 +268,50 @@ func parseCmdline() (string, config) {
 	}
 	cfg.period = *period
 
+	// -------------------------
+	// Verify grouping & sorting
+
+	groupings := aux.NewList(
+		"",
+		"ticker",
+		"type",
+		"month",
+	)
+	if !groupings.Has(*groupBy) {
+		log.Fatalf("bad grouping %s", *groupBy)
+	}
+
+	sorts := aux.NewList(
+		"date",
+		"amount",
+		"ticker",
+	)
+	if cmd == "trades" {
+		sorts.Add("yield")
+		sorts.Add("days")
+	}
+	if !sorts.Has(*sortBy) {
+		log.Fatalf("bad sort %s for %s", *sortBy, cmd)
+	}
+
+	// -----------------
+	// Verify cost basis
+
+	methods := aux.NewList(
+		"fifo",
+		"lifo",
+		"average",
+		"specific",
+	)
+	if !methods.Has(*costBasis) {
+		log.Fatalf("bad cost basis %s", *costBasis)
+	}
+	if *costBasis == "specific" && *lotsFile == "" {
+		log.Fatal("specific cost basis needs --lots")
+	}
+	cfg.costBasis = *costBasis
+	cfg.lotsFile = *lotsFile
+
 	// ----------------------
 	// Parse and verify times
 
@@ -153,14 +322,36 @@ func parseCmdline() (string, config) {
 	return cmd, cfg
 }
 
+func isAccountId(acc string) bool {
+	if acc == "" {
+		return false
+	}
+	for _, c := range acc {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func usage() {
 	fmt.Printf("usage:\n" +
 		"\t tnkinv {subcmd} [params] --token file_with_token \n" +
 		"\t   common params: \n" +
-		"\t     --account broker|iis|all \n" +
+		"\t     --account broker|iis|all|account_id \n" +
 		"\t     --operations filename \n" +
 		"\t     --fictives filename \n" +
 		"\t     --loglevel {debug|all} \n" +
+		"\t     --cost-basis fifo|lifo|average|specific (default: average) \n" +
+		"\t     --lots filename (for specific cost basis) \n" +
+		"\t     --strict (fail on unknown operation types) \n" +
+		"\t     --sections filename \n" +
+		"\t     --benchmarks filename \n" +
+		"\t     --ledger filename (operations are kept and synced there) \n" +
+		"\t     --offline (use the ledger as is) \n" +
+		"\t     --overrides filename \n" +
+		"\t     --tags filename (show, story & deals by strategy) \n" +
+		"\t     --replay FXUS:0.6,FXRU:0.4 (show & story, default: the portfolio benchmark) \n" +
 		"\t   subcmds: \n" +
 		"\t     show   [--at 1922/12/28 (default: today)] \n" +
 		"\t     story  [--start 1901/01/01 (default: year ago)] \n" +
@@ -169,21 +360,94 @@ func usage() {
 		"\t     deals  [--start 1901/01/01 (default: none)] \n" +
 		"\t            [--end 1902/02/02 (default: now)] \n" +
 		"\t            [--period day|week|month|all (default: month)] \n" +
+		"\t            [--tickers ticker1,ticker2,..] \n" +
+		"\t            [--types Buy,Coupon,..] \n" +
+		"\t            [--currency RUB|USD|EUR] \n" +
+		"\t            [--min-amount 1000] \n" +
+		"\t            [--group-by ticker|type|month] \n" +
+		"\t            [--sort date|amount|ticker (default: date)] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
+		"\t     trades [--start 1901/01/01 (default: none)] \n" +
+		"\t            [--end 1902/02/02 (default: now)] \n" +
+		"\t            [--sort date|amount|ticker|yield|days (default: date)] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
 		"\t     price  --tickers ticker1,ticker2,.. \n" +
 		"\t            [--start 1901/01/01 (default: year ago)] \n" +
 		"\t            [--end 1902/02/02 (default: now)] \n" +
+		"\t     risk   [--start 1901/01/01 (default: year ago)] \n" +
+		"\t            [--period day|week|month (default: day)] \n" +
+		"\t            [--riskfree 4.5 (annual %%, default: 0)] \n" +
+		"\t            [--benchmark FXUS:0.6,FXRU:0.4 (default: the portfolio one, or that of the biggest section)] \n" +
+		"\t            [--format human|table (default: human)] \n" +
+		"\t     analyze correlation \n" +
+		"\t            [--start 1901/01/01 (default: year ago)] \n" +
+		"\t            [--end 1902/02/02 (default: now)] \n" +
+		"\t            [--period day|week|month (default: day)] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
+		"\t     rebalance --targets filename \n" +
+		"\t            [--cash 50000 (RUB, default: 0)] \n" +
+		"\t            [--fee 0.3 (%%, default: 0.3)] \n" +
+		"\t            [--buy-only] \n" +
+		"\t     dividends [--at 1922/12/28 (default: today)] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
+		"\t     unrealized [--at 1922/12/28 (default: today)] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
+		"\t     iis    [--at 1922/12/28 (default: today)] \n" +
+		"\t            [--format human|table (default: human)] \n" +
+		"\t     sync   --ledger filename \n" +
+		"\t     import --input statement.csv|statement.xlsx --operations filename \n" +
+		"\t            [--mapping filename (default: broker report)] \n" +
+		"\t     fees   [--start 1901/01/01 (default: year ago)] \n" +
+		"\t            [--end 1902/02/02 (default: now)] \n" +
+		"\t            [--tariffs filename] \n" +
+		"\t            [--format human|table|json (default: human)] \n" +
+		"\t     backtest --strategy filename \n" +
+		"\t            [--start 1901/01/01 (default: year ago)] \n" +
+		"\t            [--period day|week|month (default: month)] \n" +
+		"\t            [--format human|table (default: human)] \n" +
 		"\t     sandbox \n")
 }
 
-func getAccountIds(c *client.MyClient, accType string) (accIds []string) {
+// offlineCmds need no prices, just the ledger and the instruments saved next to it
+var offlineCmds = []string{"deals"}
+
+type account struct {
+	id, typ string
+}
+
+func getAccounts(c *client.MyClient, cfg config) (accs []account) {
+	accType := cfg.acc
+
 	if accType == "broker" {
-		accIds = append(accIds, "")
+		accs = append(accs, account{"", "Tinkoff"})
+		return
+	}
+
+	if !aux.IsIn(accType, "iis", "all") {
+		// just the one
+		accs = append(accs, account{accType, ""})
+		return
+	}
+
+	if cfg.offline {
+		var ids []string
+		types := portfolio.LedgerAccounts(cfg.ledgerFile)
+		for id := range types {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			if accType == "all" || types[id] == "TinkoffIis" {
+				accs = append(accs, account{id, types[id]})
+			}
+		}
 		return
 	}
 
 	for _, acc := range c.RequestAccounts().Payload.Accounts {
 		if accType == "all" || acc.BrokerAccountType == "TinkoffIis" {
-			accIds = append(accIds, acc.BrokerAccountID)
+			accs = append(accs, account{acc.BrokerAccountID, acc.BrokerAccountType})
 		}
 	}
 	return
@@ -193,8 +457,14 @@ func main() {
 	cmd, cfg := parseCmdline()
 
 	if cfg.token == "" {
-		usage()
-		log.Fatal("no token provided")
+		if !cfg.offline {
+			usage()
+			log.Fatal("no token provided")
+		}
+		if !aux.IsIn(cmd, offlineCmds...) {
+			usage()
+			log.Fatalf("no token provided, %s fetches prices even offline", cmd)
+		}
 	}
 
 	c := client.NewClient(cfg.token)
@@ -210,17 +480,72 @@ func main() {
 		return
 	}
 
-	port := portfolio.NewPortfolio(c, getAccountIds(c, cfg.acc), cfg.sideOps, cfg.fictOps)
+	newPortfolio := func(accIds []string, sideOps string) *portfolio.Portfolio {
+		return portfolio.NewPortfolio(c, accIds, sideOps, cfg.fictOps).
+			WithSections(cfg.sectionsFile).
+			WithCostBasis(cfg.costBasis, cfg.lotsFile).
+			WithStrict(cfg.strict).
+			WithTwr(cmd == "show" || cmd == "story").
+			WithBenchmarks(cfg.benchmarksFile).
+			WithReplay(cfg.replay).
+			WithLedger(cfg.ledgerFile, cfg.offline).
+			WithOverrides(cfg.overrides).
+			WithTags(cfg.tagsFile)
+	}
+
+	if cmd == "story" && cfg.period == "" {
+		cfg.period = "month"
+	}
+
+	view := func(port *portfolio.Portfolio) {
+		if cmd == "show" {
+			port.Collect(cfg.at)
+			port.Print(cfg.at)
+		} else {
+			port.ListBalances(cfg.start, cfg.period, cfg.format)
+		}
+	}
+
+	accs := getAccounts(c, cfg)
+
+	var accIds []string
+	for _, acc := range accs {
+		accIds = append(accIds, acc.id)
+	}
+
+	if cmd == "show" || cmd == "story" {
+		if cfg.acc == "all" && len(accs) > 1 && cfg.fictOps == "" {
+			// every account on its own, the side operations only go to the consolidated view
+			for _, acc := range accs {
+				if acc.typ != "" {
+					fmt.Printf("#### %s %s\n", acc.typ, acc.id)
+				} else {
+					fmt.Printf("#### %s\n", acc.id)
+				}
+				view(newPortfolio([]string{acc.id}, ""))
+			}
+			fmt.Println("#### Consolidated")
+		}
 
-	if cmd == "show" {
-		port.Collect(cfg.at)
-		port.Print(cfg.at)
+		view(newPortfolio(accIds, cfg.sideOps))
 		return
 	}
 
+	port := newPortfolio(accIds, cfg.sideOps)
+
 	if cmd == "deals" {
+		filter := portfolio.DealsFilter{
+			Tickers:   cfg.tickers,
+			Types:     cfg.types,
+			Currency:  cfg.currency,
+			MinAmount: cfg.minAmount,
+			GroupBy:   cfg.groupBy,
+			SortBy:    cfg.sortBy,
+			Format:    cfg.format,
+		}
+
 		if cfg.startSet {
-			port.ListDeals(cfg.start, cfg.end)
+			port.ListDeals(cfg.start, cfg.end, filter)
 			return
 		}
 
@@ -243,16 +568,104 @@ func main() {
 			since = time.Time{}
 		}
 
-		port.ListDeals(since, cfg.end)
+		port.ListDeals(since, cfg.end, filter)
 		return
 	}
 
-	if cmd == "story" {
+	if cmd == "trades" {
+		start := time.Time{}
+		if cfg.startSet {
+			start = cfg.start
+		}
+		port.Trades(start, cfg.end, cfg.sortBy, cfg.format)
+		return
+	}
+
+	if cmd == "risk" {
+		if cfg.period == "" {
+			cfg.period = "day"
+		}
+
+		port.Risk(cfg.start, cfg.period, cfg.format, cfg.benchmark, cfg.riskFree)
+		return
+	}
+
+	if cmd == "rebalance" {
+		if cfg.targetsFile == "" {
+			usage()
+			log.Fatal("no targets provided")
+		}
+
+		port.Rebalance(cfg.targetsFile, cfg.cash, cfg.fee, cfg.isBuyOnly)
+		return
+	}
+
+	if cmd == "dividends" {
+		port.Dividends(cfg.at, cfg.format)
+		return
+	}
+
+	if cmd == "unrealized" {
+		port.Unrealized(cfg.at, cfg.format)
+		return
+	}
+
+	if cmd == "sync" {
+		if cfg.ledgerFile == "" || cfg.offline {
+			usage()
+			log.Fatal("no ledger provided")
+		}
+
+		types := make(map[string]string)
+		for _, acc := range accs {
+			types[acc.id] = acc.typ
+		}
+		log.Infof("%d operations synced", port.Sync(types))
+		return
+	}
+
+	if cmd == "import" {
+		if cfg.inputFile == "" || cfg.sideOps == "" {
+			usage()
+			log.Fatal("no statement or operations file provided")
+		}
+
+		port.Import(cfg.inputFile, cfg.mappingFile)
+		return
+	}
+
+	if cmd == "iis" {
+		port.Iis(cfg.at, cfg.format)
+		return
+	}
+
+	if cmd == "fees" {
+		port.Fees(cfg.start, cfg.end, cfg.format, cfg.tariffsFile)
+		return
+	}
+
+	if cmd == "backtest" {
+		if cfg.strategyFile == "" {
+			usage()
+			log.Fatal("no strategy provided")
+		}
 		if cfg.period == "" {
 			cfg.period = "month"
 		}
 
-		port.ListBalances(cfg.start, cfg.period, cfg.format)
+		port.WithStrategy(cfg.strategyFile, cfg.start, cfg.period).
+			ListBalances(cfg.start, cfg.period, cfg.format)
+		return
+	}
+
+	if cmd == "analyze" {
+		if cfg.period == "" {
+			cfg.period = "day"
+		}
+
+		if cfg.subcmd == "correlation" {
+			port.AnalyzeCorrelation(cfg.start, cfg.end, cfg.period, cfg.format)
+		}
 		return
 	}
 }
@@ -1,6 +1,7 @@
 package aux
 
 import (
+	"errors"
 	"math"
 	"time"
 
@@ -34,44 +35,208 @@ func (ctx *XirrCtx) AddPayment(val float64, date time.Time) {
 	})
 }
 
-func (ctx XirrCtx) Ratio(result float64, tn time.Time) float64 {
-	epsilon := result / 100000.0
-	if epsilon < 0.1 {
-		epsilon = 0.1
+// AddScaled adds the payments of other, multiplied by scale at their dates,
+// e.g. to convert them to another currency
+func (ctx *XirrCtx) AddScaled(other XirrCtx, scale func(time.Time) float64) {
+	for _, p := range other.payments {
+		ctx.AddPayment(p.val*scale(p.date), p.date)
 	}
+}
+
+var ErrNoXirr = errors.New("xirr: no solution")
 
+const (
+	xirrMaxIterations = 200
+	xirrTolerance     = 1e-9
+)
+
+// net value of the payments at tn less the result, and its derivative
+func (ctx XirrCtx) npv(rate, result float64, tn time.Time) (nv, dnv float64) {
+	nv = -result
+	for _, p := range ctx.payments {
+		years := tn.Sub(p.date).Hours() / 24.0 / 365.0
+		nv += p.val * math.Pow(1+rate, years)
+		dnv += p.val * years * math.Pow(1+rate, years-1)
+	}
+	return
+}
+
+// Ratio finds the annual rate the payments have to grow at to become result at tn.
+// Newton-Raphson goes first, bisection is the fallback when it fails to converge.
+func (ctx XirrCtx) Ratio(result float64, tn time.Time) (float64, error) {
 	if len(ctx.payments) == 0 {
-		return 0
+		return 0, ErrNoXirr
+	}
+
+	epsilon := math.Abs(result)
+	for _, p := range ctx.payments {
+		epsilon += math.Abs(p.val)
 	}
+	epsilon *= xirrTolerance
 
-	rate := 0.2
-	rate_down, rate_up, have_rate_down, have_rate_up := 0.0, 0.0, false, false
-	i := 0
+	if rate, ok := ctx.newton(result, tn, epsilon); ok {
+		return rate, nil
+	}
+
+	return ctx.bisect(result, tn, epsilon)
+}
+
+func (ctx XirrCtx) newton(result float64, tn time.Time, epsilon float64) (float64, bool) {
+	rate := 0.1
+
+	for i := 0; i < xirrMaxIterations; i++ {
+		nv, dnv := ctx.npv(rate, result, tn)
+		log.Tracef("xirr newton: rate %f nv %f", rate, nv)
 
-	for i = 0; ; i++ {
-		nv := -result
-		for _, p := range ctx.payments {
-			nv += p.val * math.Pow(1+rate, tn.Sub(p.date).Hours()/24.0/365.0)
+		if math.Abs(nv) <= epsilon {
+			return rate, true
 		}
-		log.Tracef("total %.2f e %f rate %f nv %f", result, epsilon, rate, nv)
-		if nv < -epsilon {
-			rate_down, have_rate_down = rate, true
-			if have_rate_up {
-				rate = (rate + rate_up) / 2
-			} else {
-				rate += 0.1
-			}
-		} else if nv > epsilon {
-			rate_up, have_rate_up = rate, true
-			if have_rate_down {
-				rate = (rate + rate_down) / 2
-			} else {
-				rate -= 0.1
-			}
-		} else {
+		if dnv == 0 {
+			break
+		}
+
+		next := rate - nv/dnv
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
 			break
 		}
+		rate = next
+	}
+
+	return 0, false
+}
+
+func (ctx XirrCtx) bisect(result float64, tn time.Time, epsilon float64) (float64, error) {
+	lo, hi := -0.9, 1.0
+	nvLo, _ := ctx.npv(lo, result, tn)
+	nvHi, _ := ctx.npv(hi, result, tn)
+
+	// look for the bracket
+	for i := 0; nvLo*nvHi > 0; i++ {
+		if i == xirrMaxIterations {
+			return 0, ErrNoXirr
+		}
+		lo = -1 + (1+lo)/2
+		hi = hi*2 + 1
+		nvLo, _ = ctx.npv(lo, result, tn)
+		nvHi, _ = ctx.npv(hi, result, tn)
+	}
+
+	for i := 0; i < xirrMaxIterations; i++ {
+		rate := (lo + hi) / 2
+		nv, _ := ctx.npv(rate, result, tn)
+		log.Tracef("xirr bisect: rate %f nv %f", rate, nv)
+
+		if math.Abs(nv) <= epsilon || hi-lo < xirrTolerance {
+			return rate, nil
+		}
+
+		if nv*nvLo > 0 {
+			lo, nvLo = rate, nv
+		} else {
+			hi = rate
+		}
+	}
+
+	return 0, ErrNoXirr
+}
+
+// =============================================================================
+
+// TwrCtx chains the returns of the sub-periods between cash flows,
+// so that the flows do not affect the result
+type TwrCtx struct {
+	ratio float64 // chained up to the base
+	base  float64 // the value right after the latest flow
+	value float64 // the latest one
+	flows float64 // the total by the latest AddPeriod
+
+	snap, last float64 // Ratio at the latest snapshot, and of the period before it
+
+	start time.Time
+}
+
+func (ctx *TwrCtx) begin(value float64, t time.Time) {
+	ctx.ratio, ctx.snap, ctx.last = 1, 1, 1
+	ctx.base, ctx.value = value, value
+	ctx.start = t
+}
+
+// AddFlow closes the sub-period at the cash flow; value is the one right before it
+func (ctx *TwrCtx) AddFlow(value, flow float64, t time.Time) {
+	if !ctx.Valid() {
+		if value+flow > 0 {
+			ctx.begin(value+flow, t)
+		}
+		return
+	}
+
+	if ctx.base > 0 {
+		ctx.ratio *= value / ctx.base
+	}
+	ctx.base = value + flow
+	ctx.value = ctx.base
+}
+
+// AddSnapshot values the running sub-period, and starts a new reporting period
+func (ctx *TwrCtx) AddSnapshot(value float64, t time.Time) {
+	if !ctx.Valid() {
+		return
+	}
+
+	ctx.value = value
+	r := ctx.Ratio()
+	ctx.last = r / ctx.snap
+	ctx.snap = r
+}
+
+// AddPeriod is for when there are only the snapshots, and flows are the total payins by then.
+// Modified Dietz: the flows since the previous snapshot are considered to come in the middle
+func (ctx *TwrCtx) AddPeriod(value, flows float64, t time.Time) {
+	flow := flows - ctx.flows
+	ctx.flows = flows
+
+	if !ctx.Valid() {
+		if value > 0 {
+			ctx.begin(value, t)
+		}
+		return
+	}
+
+	ctx.last = 1
+	if base := ctx.value + flow/2; base > 0 {
+		ctx.last = 1 + (value-ctx.value-flow)/base
+	}
+	ctx.ratio = ctx.Ratio() * ctx.last
+	ctx.base, ctx.value = value, value
+	ctx.snap = ctx.ratio
+}
+
+func (ctx TwrCtx) Valid() bool {
+	return !ctx.start.IsZero()
+}
+
+// Ratio is the chained ratio since the first flow
+func (ctx TwrCtx) Ratio() float64 {
+	if !ctx.Valid() {
+		return 1
+	}
+	if ctx.base <= 0 {
+		return ctx.ratio
+	}
+	return ctx.ratio * ctx.value / ctx.base
+}
+
+// Last is the ratio of the latest period between snapshots
+func (ctx TwrCtx) Last() float64 {
+	if !ctx.Valid() {
+		return 1
+	}
+	return ctx.last
+}
+
+func (ctx TwrCtx) Annual(t time.Time) float64 {
+	if !ctx.Valid() || !t.After(ctx.start) {
+		return 1
 	}
-	log.Infof("xirr took %d iterations, rate=%.2f, e=%.1f", i, rate, epsilon)
-	return rate
+	return RatioAnnual(ctx.Ratio(), t.Sub(ctx.start))
 }
@@ -1,6 +1,7 @@
 package aux
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -9,12 +10,176 @@ func date(year int, month time.Month, day int) time.Time {
 	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
 }
 
+type xirrPayment struct {
+	val  float64
+	date time.Time
+}
+
 func TestXirr(t *testing.T) {
-	var ctx XirrCtx
-	ctx.AddPayment(100, date(2002, 1, 1))
-	ctx.AddPayment(100, date(2003, 1, 1))
-	rate := ctx.Ratio(231, date(2004, 1, 1))
-	if rate != 0.1 {
-		t.Errorf("xiir() = %f, exp 0.1", rate)
+	tests := []struct {
+		name     string
+		payments []xirrPayment
+		result   float64
+		tn       time.Time
+		exp      float64
+	}{
+		{
+			name: "two payins",
+			payments: []xirrPayment{
+				{100, date(2002, 1, 1)},
+				{100, date(2003, 1, 1)},
+			},
+			result: 231,
+			tn:     date(2004, 1, 1),
+			exp:    0.1,
+		},
+		{
+			name:     "loss",
+			payments: []xirrPayment{{100, date(2002, 1, 1)}},
+			result:   90,
+			tn:       date(2003, 1, 1),
+			exp:      -0.1,
+		},
+		{
+			name:     "nothing left",
+			payments: []xirrPayment{{100, date(2002, 1, 1)}},
+			result:   1,
+			tn:       date(2003, 1, 1),
+			exp:      -0.99,
+		},
+		{
+			name: "withdrawal",
+			payments: []xirrPayment{
+				{100, date(2002, 1, 1)},
+				{-55, date(2003, 1, 1)},
+			},
+			result: 60.5,
+			tn:     date(2004, 1, 1),
+			exp:    0.1,
+		},
+		{
+			name:     "short period",
+			payments: []xirrPayment{{100, date(2002, 1, 1)}},
+			result:   101,
+			tn:       date(2002, 1, 8),
+			exp:      math.Pow(1.01, 365.0/7) - 1,
+		},
+		{
+			name:     "unchanged",
+			payments: []xirrPayment{{100, date(2002, 1, 1)}},
+			result:   100,
+			tn:       date(2004, 1, 1),
+			exp:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		var ctx XirrCtx
+		for _, p := range tt.payments {
+			ctx.AddPayment(p.val, p.date)
+		}
+
+		rate, err := ctx.Ratio(tt.result, tt.tn)
+		if err != nil {
+			t.Errorf("%s: xirr() failed: %s", tt.name, err)
+			continue
+		}
+		if math.Abs(rate-tt.exp) > 1e-6*math.Max(1, math.Abs(tt.exp)) {
+			t.Errorf("%s: xirr() = %f, exp %f", tt.name, rate, tt.exp)
+		}
+	}
+}
+
+func TestXirrNoSolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		payments []xirrPayment
+		result   float64
+	}{
+		{
+			name: "no payments",
+		},
+		{
+			name:     "negative result",
+			payments: []xirrPayment{{100, date(2002, 1, 1)}},
+			result:   -50,
+		},
+		{
+			name: "only withdrawals",
+			payments: []xirrPayment{
+				{-100, date(2002, 1, 1)},
+				{-100, date(2003, 1, 1)},
+			},
+			result: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		var ctx XirrCtx
+		for _, p := range tt.payments {
+			ctx.AddPayment(p.val, p.date)
+		}
+
+		if rate, err := ctx.Ratio(tt.result, date(2004, 1, 1)); err == nil {
+			t.Errorf("%s: xirr() = %f, exp error", tt.name, rate)
+		}
+	}
+}
+
+func TestXirrAddScaled(t *testing.T) {
+	var usd XirrCtx
+	usd.AddPayment(100, date(2002, 1, 1))
+
+	var rub XirrCtx
+	rub.AddScaled(usd, func(time.Time) float64 { return 30 })
+
+	// the dollar has not moved, neither has the rate
+	rate, err := rub.Ratio(3000, date(2003, 1, 1))
+	if err != nil || math.Abs(rate) > 1e-6 {
+		t.Errorf("got %v, %v", rate, err)
+	}
+}
+
+func TestTwr(t *testing.T) {
+	var ctx TwrCtx
+
+	// 100 in, grows 10%, 110 more in, falls 50%
+	ctx.AddFlow(0, 100, date(2002, 1, 1))
+	ctx.AddSnapshot(105, date(2002, 2, 1))
+	ctx.AddFlow(110, 110, date(2002, 3, 1))
+	ctx.AddSnapshot(110, date(2002, 4, 1))
+
+	// the money put in just before the fall does not matter
+	if exp := 1.1 * 0.5; math.Abs(ctx.Ratio()-exp) > 1e-9 {
+		t.Errorf("ratio %f, exp %f", ctx.Ratio(), exp)
+	}
+	if exp := 0.55 / 1.05; math.Abs(ctx.Last()-exp) > 1e-9 {
+		t.Errorf("last %f, exp %f", ctx.Last(), exp)
+	}
+
+	// everything out, and in again
+	ctx.AddFlow(110, -110, date(2002, 5, 1))
+	ctx.AddFlow(0, 50, date(2002, 6, 1))
+	ctx.AddSnapshot(60, date(2002, 7, 1))
+	if exp := 0.55 * 1.2; math.Abs(ctx.Ratio()-exp) > 1e-9 {
+		t.Errorf("ratio after withdrawal %f, exp %f", ctx.Ratio(), exp)
+	}
+}
+
+func TestTwrPeriods(t *testing.T) {
+	var ctx TwrCtx
+
+	ctx.AddPeriod(0, 0, date(2002, 1, 1))
+	if ctx.Valid() {
+		t.Errorf("valid with nothing")
+	}
+
+	ctx.AddPeriod(100, 100, date(2002, 2, 1))
+	ctx.AddPeriod(110, 100, date(2002, 3, 1))
+	// 100 more in the middle of the period
+	ctx.AddPeriod(220, 200, date(2002, 4, 1))
+
+	if exp := 1.1 * (1 + 10.0/160); math.Abs(ctx.Ratio()-exp) > 1e-9 {
+		t.Errorf("ratio %f, exp %f", ctx.Ratio(), exp)
 	}
 }
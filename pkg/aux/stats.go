@@ -0,0 +1,99 @@
+package aux
+
+import (
+	"math"
+)
+
+func Mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// sample covariance
+func Covariance(xs, ys []float64) float64 {
+	if len(xs) < 2 || len(xs) != len(ys) {
+		return 0
+	}
+
+	mx, my := Mean(xs), Mean(ys)
+
+	var sum float64
+	for i := range xs {
+		sum += (xs[i] - mx) * (ys[i] - my)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+func StdDev(xs []float64) float64 {
+	return math.Sqrt(Covariance(xs, xs))
+}
+
+// DownsideDev only counts the values below the target
+func DownsideDev(xs []float64, target float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, x := range xs {
+		if x < target {
+			sum += (x - target) * (x - target)
+		}
+	}
+	return math.Sqrt(sum / float64(len(xs)))
+}
+
+func Correlation(xs, ys []float64) float64 {
+	d := StdDev(xs) * StdDev(ys)
+	if d == 0 {
+		return 0
+	}
+	return Covariance(xs, ys) / d
+}
+
+func Beta(xs, bench []float64) float64 {
+	v := Covariance(bench, bench)
+	if v == 0 {
+		return 0
+	}
+	return Covariance(xs, bench) / v
+}
+
+// MaxDrawdown returns the biggest fall of the index from its peak (0.2 for -20%),
+// the peak index, and the index it has recovered at (or the last one)
+func MaxDrawdown(index []float64) (dd float64, from, to int) {
+	peak := 0
+
+	for i, v := range index {
+		if v > index[peak] {
+			peak = i
+		}
+		if index[peak] <= 0 {
+			continue
+		}
+		if d := 1 - v/index[peak]; d > dd {
+			dd, from = d, peak
+		}
+	}
+
+	if dd == 0 {
+		return 0, 0, 0
+	}
+
+	to = len(index) - 1
+	for i := from + 1; i < len(index); i++ {
+		if index[i] >= index[from] {
+			to = i
+			break
+		}
+	}
+
+	return
+}
@@ -0,0 +1,39 @@
+package aux
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMaxDrawdown(t *testing.T) {
+	tests := []struct {
+		index    []float64
+		dd       float64
+		from, to int
+	}{
+		{[]float64{1, 1.1, 1.2}, 0, 0, 0},
+		{[]float64{1, 2, 1, 1.5, 2.5}, 0.5, 1, 4},
+		{[]float64{1, 2, 1.5, 1, 1.2}, 0.5, 1, 4},
+		{[]float64{2, 1, 3, 1.5, 3.5}, 0.5, 0, 2},
+	}
+
+	for _, tt := range tests {
+		dd, from, to := MaxDrawdown(tt.index)
+		if math.Abs(dd-tt.dd) > 1e-9 || from != tt.from || to != tt.to {
+			t.Errorf("MaxDrawdown(%v) = %f, %d, %d; exp %f, %d, %d",
+				tt.index, dd, from, to, tt.dd, tt.from, tt.to)
+		}
+	}
+}
+
+func TestBeta(t *testing.T) {
+	bench := []float64{0.01, -0.02, 0.03, 0.00}
+	xs := []float64{0.02, -0.04, 0.06, 0.00}
+
+	if b := Beta(xs, bench); math.Abs(b-2) > 1e-9 {
+		t.Errorf("Beta() = %f, exp 2", b)
+	}
+	if c := Correlation(xs, bench); math.Abs(c-1) > 1e-9 {
+		t.Errorf("Correlation() = %f, exp 1", c)
+	}
+}
@@ -37,6 +37,10 @@ func (o optional) Value() interface{} {
 }
 
 func (c *MyClient) getToken(fname string) string {
+	if fname == "" {
+		log.Fatal("no token provided, the API is needed")
+	}
+
 	b, err := ioutil.ReadFile(fname)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,280 @@
+package portfolio
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../candles"
+	"../client"
+	"../schema"
+)
+
+// Strategy is an allocation replayed over the history with fictive operations
+type Strategy struct {
+	Targets      []Target
+	Initial      float64 // RUB
+	Contribution float64 // RUB, every month
+	Rebalance    string  // month|quarter|year|threshold|none
+	Threshold    float64 // %, drift of any target to rebalance at
+	Commission   float64 // %
+}
+
+func readStrategy(fname string) (st Strategy) {
+	readJSON(fname, &st)
+
+	return
+}
+
+// WithStrategy replaces the real operations with the ones the strategy would make since start
+func (p *Portfolio) WithStrategy(fname string, start time.Time, period string) *Portfolio {
+	p.config.strategyFile = fname
+	p.config.strategyStart = start
+	p.config.strategyPeriod = period
+	return p
+}
+
+func (p *Portfolio) isFictive() bool {
+	return p.config.fictFile != "" || p.config.strategyFile != ""
+}
+
+type backtest struct {
+	Strategy
+
+	items []*rebalanceItem
+	held  []int
+	cash  map[string]float64
+
+	rebalanced time.Time
+	rebalances int
+	fees       float64 // RUB
+
+	ops []schema.Operation
+}
+
+func (bt backtest) isDue(t time.Time, total float64) bool {
+	if bt.Rebalance == "threshold" {
+		if bt.rebalances == 0 {
+			// the first allocation
+			return true
+		}
+		for _, it := range bt.items {
+			if it.price == 0 {
+				// not traded yet
+				continue
+			}
+			if math.Abs(100*it.current/total-it.target.Weight) > bt.Threshold {
+				return true
+			}
+		}
+		return false
+	}
+
+	next := nextRebalance(bt.rebalanced, bt.Rebalance)
+	return !next.IsZero() && !t.Before(next)
+}
+
+func (bt *backtest) addOp(op schema.Operation, t time.Time) {
+	op.Date = t.Format(time.RFC3339)
+	op.Status = "Done"
+	if op.Quantity_ != 0 {
+		op.Trades = []schema.Trade{
+			schema.Trade{
+				Date:     op.Date,
+				Price:    op.Price,
+				Quantity: op.Quantity_,
+			},
+		}
+	}
+	bt.ops = append(bt.ops, op)
+}
+
+func (bt *backtest) addTrade(ins schema.Instrument, units int, price float64, t time.Time) {
+	typ := "Buy"
+	if units < 0 {
+		typ = "Sell"
+	}
+	amount := price * float64(units)
+	commission := math.Abs(amount) * bt.Commission / 100
+
+	bt.addOp(schema.Operation{
+		Figi:           ins.Figi,
+		InstrumentType: string(ins.Type),
+		OperationType:  typ,
+
+		Price:      price,
+		Currency:   ins.Currency,
+		Quantity_:  uint(math.Abs(float64(units))),
+		Payment:    -amount,
+		Commission: schema.NewCValue(-commission, ins.Currency),
+	}, t)
+
+	bt.cash[ins.Currency] -= amount + commission
+}
+
+// trade makes the planned deals, selling first and exchanging the missing currency
+func (bt *backtest) trade(t time.Time, xchgrate func(curr_from, curr_to string, t time.Time) float64) {
+	need := make(map[string]float64)
+
+	for i, it := range bt.items {
+		if it.units < 0 {
+			bt.addTrade(it.ins, it.units, it.price, t.Add(time.Second))
+		} else if it.units > 0 {
+			need[it.ins.Currency] += it.price * float64(it.units) * (1 + bt.Commission/100)
+		}
+		bt.held[i] += it.units
+		bt.fees += math.Abs(it.amount()) * bt.Commission / 100
+	}
+
+	for cur, amount := range need {
+		if cur == "RUB" || amount <= bt.cash[cur] {
+			continue
+		}
+		if cur != "USD" {
+			log.Fatalf("backtest can not buy %s", cur)
+		}
+
+		rate := xchgrate(cur, "RUB", t)
+		q := math.Ceil(amount - bt.cash[cur])
+		bt.addOp(schema.Operation{
+			Figi:           schema.FigiUSD,
+			InstrumentType: string(schema.InsTypeCurrency),
+			OperationType:  "Buy",
+
+			Price:     rate,
+			Currency:  "RUB",
+			Quantity_: uint(q),
+			Payment:   -rate * q,
+		}, t.Add(2*time.Second))
+
+		bt.cash["RUB"] -= rate * q
+		bt.cash[cur] += q
+	}
+
+	// the proceeds of USD sells pay for RUB buys
+	if short := need["RUB"] - bt.cash["RUB"]; short > 0 {
+		rate := xchgrate("USD", "RUB", t)
+		q := math.Min(math.Ceil(short/rate), math.Floor(bt.cash["USD"]-need["USD"]))
+		if q > 0 {
+			bt.addOp(schema.Operation{
+				Figi:           schema.FigiUSD,
+				InstrumentType: string(schema.InsTypeCurrency),
+				OperationType:  "Sell",
+
+				Price:     rate,
+				Currency:  "RUB",
+				Quantity_: uint(q),
+				Payment:   rate * q,
+			}, t.Add(2*time.Second))
+
+			bt.cash["RUB"] += rate * q
+			bt.cash["USD"] -= q
+		}
+	}
+
+	for _, it := range bt.items {
+		if it.units > 0 {
+			bt.addTrade(it.ins, it.units, it.price, t.Add(3*time.Second))
+		}
+	}
+}
+
+func fetchBacktest(c *client.MyClient, cc *candles.CandleCache, benchmarks *schema.Benchmarks,
+	fname string) []schema.Operation {
+	bt := backtest{
+		Strategy: readStrategy(fname),
+		cash:     make(map[string]float64),
+	}
+
+	for _, target := range bt.Targets {
+		ticker := target.Ticker
+		if ticker == "" {
+			ticker = benchmarks.Of(schema.Instrument{Section: target.Section})
+			if ticker == "" || strings.Contains(ticker, ",") {
+				log.Fatalf("no ticker to buy %s with", target.Section)
+			}
+		}
+
+		ins, err := c.TryRequestByTicker(ticker)
+		if err != nil {
+			log.Fatalf("bad ticker %s: %s", ticker, err)
+		}
+
+		bt.items = append(bt.items, &rebalanceItem{target: target, ins: ins})
+		bt.held = append(bt.held, 0)
+	}
+
+	bt.run(cc.ListTimes(), cc.Get, cc.Xchgrate)
+
+	log.Infof("backtest: %d rebalancings, commissions %.0f RUB", bt.rebalances, bt.fees)
+
+	return bt.ops
+}
+
+// run contributes and rebalances at every time
+func (bt *backtest) run(times []time.Time, price func(figi string, t time.Time) float64,
+	xchgrate func(curr_from, curr_to string, t time.Time) float64) {
+	var prev time.Time
+	for _, t := range times {
+		contribution := bt.Contribution
+		if prev.IsZero() {
+			contribution = bt.Initial
+			bt.rebalanced = t
+		} else if t.Month() == prev.Month() {
+			contribution = 0
+		}
+		prev = t
+
+		if contribution > 0 {
+			bt.addOp(schema.Operation{
+				OperationType: "PayIn",
+				Payment:       contribution,
+				Currency:      "RUB",
+			}, t)
+			bt.cash["RUB"] += contribution
+		}
+
+		var active []*rebalanceItem
+		usdRate := xchgrate("USD", "RUB", t)
+		total := bt.cash["RUB"] + bt.cash["USD"]*usdRate
+		cash := total
+		isExchanged := bt.cash["USD"] != 0
+		for i, it := range bt.items {
+			isExchanged = isExchanged || it.ins.Currency != "RUB"
+			it.price = price(it.ins.Figi, t)
+			it.rate = xchgrate(it.ins.Currency, "RUB", t)
+			it.current = float64(bt.held[i]) * it.price * it.rate
+			it.held = bt.held[i]
+			it.units = 0
+			total += it.current
+
+			if it.price != 0 {
+				active = append(active, it)
+			}
+		}
+
+		isDue := bt.isDue(t, total)
+		if total <= 0 || !isDue && contribution == 0 {
+			continue
+		}
+
+		for _, it := range active {
+			it.desired = total * it.target.Weight / 100
+		}
+		left := cash
+		if isExchanged {
+			// USD is exchanged in whole units, one more than needed at worst
+			left -= usdRate
+		}
+		planDeals(active, cash, left, bt.Commission/100, !isDue)
+
+		if isDue {
+			bt.rebalanced = t
+			bt.rebalances++
+		}
+
+		bt.trade(t, xchgrate)
+	}
+}
@@ -0,0 +1,260 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestBacktestIsDue(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	item := func(weight, price, current float64) *rebalanceItem {
+		return &rebalanceItem{target: Target{Weight: weight}, price: price, current: current}
+	}
+
+	tests := []struct {
+		name       string
+		rebalance  string
+		rebalances int
+		rebalanced time.Time
+		items      []*rebalanceItem
+		t          time.Time
+		exp        bool
+	}{
+		{
+			name:      "threshold, first allocation",
+			rebalance: "threshold",
+			items:     []*rebalanceItem{item(50, 100, 0), item(50, 100, 0)},
+			t:         date(1, 15),
+			exp:       true,
+		},
+		{
+			name:       "threshold, within",
+			rebalance:  "threshold",
+			rebalances: 1,
+			items:      []*rebalanceItem{item(50, 100, 52), item(50, 100, 48)},
+			t:          date(1, 15),
+			exp:        false,
+		},
+		{
+			name:       "threshold, drifted",
+			rebalance:  "threshold",
+			rebalances: 1,
+			items:      []*rebalanceItem{item(50, 100, 60), item(50, 100, 40)},
+			t:          date(1, 15),
+			exp:        true,
+		},
+		{
+			name:       "threshold, no price yet",
+			rebalance:  "threshold",
+			rebalances: 1,
+			items:      []*rebalanceItem{item(50, 100, 50), item(50, 0, 0)},
+			t:          date(1, 15),
+			exp:        false,
+		},
+		{
+			name:       "month, same month",
+			rebalance:  "month",
+			rebalances: 1,
+			rebalanced: date(1, 15),
+			t:          date(1, 31),
+			exp:        false,
+		},
+		{
+			name:       "month, next month",
+			rebalance:  "month",
+			rebalances: 1,
+			rebalanced: date(1, 15),
+			t:          date(2, 1),
+			exp:        true,
+		},
+		{
+			name:       "quarter, within",
+			rebalance:  "quarter",
+			rebalances: 1,
+			rebalanced: date(1, 15),
+			t:          date(3, 31),
+			exp:        false,
+		},
+		{
+			name:       "quarter, next",
+			rebalance:  "quarter",
+			rebalances: 1,
+			rebalanced: date(1, 15),
+			t:          date(4, 1),
+			exp:        true,
+		},
+		{
+			name:      "none",
+			rebalance: "none",
+			t:         date(12, 31),
+			exp:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		bt := backtest{
+			Strategy:   Strategy{Rebalance: tt.rebalance, Threshold: 5},
+			items:      tt.items,
+			rebalanced: tt.rebalanced,
+			rebalances: tt.rebalances,
+		}
+		if got := bt.isDue(tt.t, 100); got != tt.exp {
+			t.Errorf("%s: got %t, expected %t", tt.name, got, tt.exp)
+		}
+	}
+}
+
+func newBacktest(st Strategy, instruments ...schema.Instrument) *backtest {
+	bt := &backtest{
+		Strategy: st,
+		cash:     make(map[string]float64),
+	}
+	for i, ins := range instruments {
+		bt.items = append(bt.items, &rebalanceItem{target: st.Targets[i], ins: ins})
+		bt.held = append(bt.held, 0)
+	}
+	return bt
+}
+
+// prices by figi, one per time
+func backtestPrices(times []time.Time, prices map[string][]float64) func(figi string, t time.Time) float64 {
+	return func(figi string, t time.Time) float64 {
+		for i := range times {
+			if times[i].Equal(t) {
+				return prices[figi][i]
+			}
+		}
+		return 0
+	}
+}
+
+func backtestXchgrate(curr_from, curr_to string, t time.Time) float64 {
+	if curr_from == curr_to {
+		return 1
+	}
+	if curr_from == "USD" {
+		return 100
+	}
+	return 0.01
+}
+
+var (
+	backtestRUB = schema.Instrument{Figi: "RUB1", Ticker: "RUB1", Currency: "RUB", Lot: 1}
+	backtestUSD = schema.Instrument{Figi: "USD1", Ticker: "USD1", Currency: "USD", Lot: 1}
+)
+
+func TestBacktestContributions(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	times := []time.Time{date(1, 11), date(1, 25), date(2, 1), date(2, 15), date(3, 1)}
+	bt := newBacktest(Strategy{
+		Targets:      []Target{{Ticker: "RUB1", Weight: 100}},
+		Initial:      1000,
+		Contribution: 100,
+		Rebalance:    "none",
+	}, backtestRUB)
+	bt.run(times, backtestPrices(times, map[string][]float64{"RUB1": {10, 10, 10, 10, 10}}), backtestXchgrate)
+
+	payins := make(map[string]float64)
+	for _, op := range bt.ops {
+		if op.OperationType == "PayIn" {
+			payins[op.Date] += op.Payment
+		}
+	}
+	exp := map[time.Time]float64{date(1, 11): 1000, date(2, 1): 100, date(3, 1): 100}
+	if len(payins) != len(exp) {
+		t.Errorf("got %d payins, expected %d", len(payins), len(exp))
+	}
+	for d, amount := range exp {
+		if got := payins[d.Format(time.RFC3339)]; got != amount {
+			t.Errorf("payin at %s: got %.0f, expected %.0f", d.Format("2006-01-02"), got, amount)
+		}
+	}
+	if bt.held[0] != 120 {
+		t.Errorf("got %d held, expected 120", bt.held[0])
+	}
+}
+
+func TestBacktestThreshold(t *testing.T) {
+	date := func(day int) time.Time {
+		return time.Date(2021, 1, day, 0, 0, 0, 0, time.UTC)
+	}
+	times := []time.Time{date(1), date(2), date(3)}
+	bt := newBacktest(Strategy{
+		Targets:   []Target{{Ticker: "RUB1", Weight: 50}, {Ticker: "RUB2", Weight: 50}},
+		Initial:   1000,
+		Rebalance: "threshold",
+		Threshold: 5,
+	}, backtestRUB, schema.Instrument{Figi: "RUB2", Ticker: "RUB2", Currency: "RUB", Lot: 1})
+	prices := map[string][]float64{
+		"RUB1": {10, 11, 15}, // 52% within the threshold, then 60%
+		"RUB2": {10, 10, 10},
+	}
+	bt.run(times, backtestPrices(times, prices), backtestXchgrate)
+
+	if bt.rebalances != 2 {
+		t.Errorf("got %d rebalances, expected 2", bt.rebalances)
+	}
+	if !bt.rebalanced.Equal(date(3)) {
+		t.Errorf("rebalanced at %s, expected at %s", bt.rebalanced, date(3))
+	}
+	// 50+50 bought, then 1250 RUB is 42+62
+	if bt.held[0] != 42 || bt.held[1] != 62 {
+		t.Errorf("got %v held, expected [42 62]", bt.held)
+	}
+}
+
+func TestBacktestCash(t *testing.T) {
+	date := func(month time.Month) time.Time {
+		return time.Date(2021, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	times := []time.Time{date(1), date(2), date(3)}
+	bt := newBacktest(Strategy{
+		Targets:    []Target{{Ticker: "RUB1", Weight: 50}, {Ticker: "USD1", Weight: 50}},
+		Initial:    10000,
+		Rebalance:  "month",
+		Commission: 0.3,
+	}, backtestRUB, backtestUSD)
+	prices := map[string][]float64{
+		"RUB1": {10, 10, 10},
+		"USD1": {1, 2, 1}, // USD1 overweight, then underweight
+	}
+	bt.run(times, backtestPrices(times, prices), backtestXchgrate)
+
+	// ops are made in time order, so the cash must never run short
+	cash := make(map[string]float64)
+	exchanged := make(map[string]bool)
+	for _, op := range bt.ops {
+		cash[op.Currency] += op.Payment + op.Commission.Value
+		if op.Figi == schema.FigiUSD {
+			exchanged[op.OperationType] = true
+			q := float64(op.Quantity_)
+			if op.OperationType == "Sell" {
+				q = -q
+			}
+			cash["USD"] += q
+		}
+		for cur, amount := range cash {
+			if amount < -1e-6 {
+				t.Errorf("%s %s: %s cash is %.2f", op.Date, op.OperationType, cur, amount)
+			}
+		}
+	}
+	for cur, amount := range bt.cash {
+		if math.Abs(cash[cur]-amount) > 1e-6 {
+			t.Errorf("%s: ops make %.2f, expected %.2f", cur, cash[cur], amount)
+		}
+	}
+	if !exchanged["Buy"] || !exchanged["Sell"] {
+		t.Errorf("got USD exchanges %v, expected both buys and sells", exchanged)
+	}
+	if bt.rebalances != 2 {
+		t.Errorf("got %d rebalances, expected 2", bt.rebalances)
+	}
+}
@@ -0,0 +1,239 @@
+package portfolio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../aux"
+	"../schema"
+)
+
+// BenchmarksConfig maps sections and tickers to benchmarks.
+// A benchmark is a ticker or a mix like "FXUS:0.6,FXRU:0.4",
+// mixes are rebalanced every Rebalance (month|quarter|year|none)
+type BenchmarksConfig struct {
+	Portfolio string
+	Sections  map[schema.Section]string
+	Tickers   map[string]string
+	Rebalance string
+}
+
+func (p *Portfolio) WithBenchmarks(fname string) *Portfolio {
+	if fname == "" {
+		return p
+	}
+
+	var cfg BenchmarksConfig
+	readJSON(fname, &cfg)
+
+	p.benchmarks = &schema.Benchmarks{Sections: cfg.Sections, Tickers: cfg.Tickers}
+
+	p.config.benchmark = cfg.Portfolio
+	if cfg.Rebalance != "" {
+		p.config.benchRebalance = cfg.Rebalance
+	}
+
+	return p
+}
+
+// parseBenchmark returns tickers and their normalized weights
+func parseBenchmark(spec string) (tickers []string, weights []float64) {
+	total := 0.0
+
+	for _, part := range strings.Split(spec, ",") {
+		ticker, weight := part, 1.0
+
+		if i := strings.Index(part, ":"); i >= 0 {
+			ticker = part[:i]
+			w, err := strconv.ParseFloat(part[i+1:], 64)
+			if err != nil {
+				log.Fatalf("bad benchmark %s: %s", spec, err)
+			}
+			weight = w
+		}
+
+		tickers = append(tickers, strings.TrimSpace(ticker))
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	for i := range weights {
+		weights[i] /= total
+	}
+
+	return
+}
+
+func nextRebalance(t time.Time, period string) time.Time {
+	months := map[string]int{
+		"month":   1,
+		"quarter": 3,
+		"year":    12,
+	}[period]
+	if months == 0 {
+		return time.Time{}
+	}
+
+	m := int(t.Month()) - 1
+	m = m - m%months + months
+	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()).AddDate(0, m, 0)
+}
+
+// benchIndex returns the benchmark price in currency,
+// a mix is an index of the periodically rebalanced parts (1 at the first requested time)
+func (p *Portfolio) benchIndex(spec, currency string) schema.PriceAt {
+	if spec == "" {
+		return nil
+	}
+
+	tickers, weights := parseBenchmark(spec)
+
+	var inss []schema.Instrument
+	for _, ticker := range tickers {
+		inss = append(inss, p.insByTicker(ticker))
+	}
+
+	if len(inss) == 1 {
+		return func(t time.Time) float64 {
+			return p.cc.GetInCurrency(inss[0], currency, t)
+		}
+	}
+
+	// the mix growth between rebalancings
+	segment := func(from, to time.Time) float64 {
+		g, wsum := 0.0, 0.0
+		for i, ins := range inss {
+			p0 := p.cc.GetInCurrency(ins, currency, from)
+			if p0 == 0 {
+				// not traded yet
+				continue
+			}
+			g += weights[i] * p.cc.GetInCurrency(ins, currency, to) / p0
+			wsum += weights[i]
+		}
+		if wsum == 0 {
+			return 1
+		}
+		return g / wsum
+	}
+
+	growth := func(from, to time.Time) float64 {
+		g := 1.0
+		for from.Before(to) {
+			next := nextRebalance(from, p.config.benchRebalance)
+			if next.IsZero() || next.After(to) {
+				next = to
+			}
+			g *= segment(from, next)
+			from = next
+		}
+		return g
+	}
+
+	var anchor time.Time
+	return func(t time.Time) float64 {
+		if anchor.IsZero() {
+			anchor = t
+		}
+		if t.Before(anchor) {
+			return 1 / growth(t, anchor)
+		}
+		return growth(anchor, t)
+	}
+}
+
+// benchmark is a ticker or a mix, see schema.Benchmarks
+func (p *Portfolio) benchmark(ins schema.Instrument) string {
+	return p.benchmarks.Of(ins)
+}
+
+func (p *Portfolio) benchPricef(ins schema.Instrument) schema.PriceAt {
+	return p.benchIndex(p.benchmark(ins), ins.Currency)
+}
+
+// =============================================================================
+
+// benchReplay pretends every payin has bought the benchmark
+type benchReplay struct {
+	pricef   schema.PriceAt
+	quantity float64
+	payins   float64
+
+	xirr aux.XirrCtx
+
+	start      time.Time // of the first payin
+	startPrice float64
+}
+
+func (r *benchReplay) addPayin(value float64, t time.Time) {
+	price := r.pricef(t)
+	if price == 0 {
+		return
+	}
+
+	if r.start.IsZero() {
+		r.start, r.startPrice = t, price
+	}
+
+	r.quantity += value / price
+	r.payins += value
+	r.xirr.AddPayment(value, t)
+}
+
+func (r benchReplay) value(t time.Time) float64 {
+	return r.quantity * r.pricef(t)
+}
+
+// the replay is always fully invested, so its twr is just the benchmark growth
+func (r benchReplay) twr(t time.Time) float64 {
+	if r.start.IsZero() {
+		return 1
+	}
+	return r.pricef(t) / r.startPrice
+}
+
+func (r benchReplay) String(t time.Time, style string) string {
+	value := r.value(t)
+
+	rate, err := r.xirr.Ratio(value, t)
+	xirr := "  n/a"
+	if err == nil {
+		xirr = fmt.Sprintf("%5.1f%%", rate*100)
+	} else {
+		rate = 0
+	}
+
+	twr := r.twr(t)
+	twrAnnual := 1.0
+	if t.After(r.start) && !r.start.IsZero() {
+		twrAnnual = aux.RatioAnnual(twr, t.Sub(r.start))
+	}
+
+	if style == schema.TableStyle {
+		return fmt.Sprintf(", %.0f, %.1f, %.0f, %.1f", value, rate*100, value-r.payins, aux.Ratio2Perc(twr))
+	}
+
+	return fmt.Sprintf("%7.0f : %6.0f (annual %s; twr %5.1f%%, annual %5.1f%%)",
+		value, value-r.payins, xirr, aux.Ratio2Perc(twr), aux.Ratio2Perc(twrAnnual))
+}
+
+func (p *Portfolio) newBenchReplay() *benchReplay {
+	pricef := p.benchIndex(p.config.benchmark, "RUB")
+	if pricef == nil {
+		return nil
+	}
+	return &benchReplay{pricef: pricef}
+}
+
+// WithReplay sets the benchmark (or a mix) the payins are replayed into,
+// it overrides the portfolio benchmark
+func (p *Portfolio) WithReplay(spec string) *Portfolio {
+	if spec != "" {
+		p.config.benchmark = spec
+	}
+	return p
+}
@@ -0,0 +1,181 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../aux"
+	"../candles"
+	"../schema"
+)
+
+type concentration struct {
+	Ticker    string
+	Weight    float64 // %
+	RiskShare float64 // %
+}
+
+type correlationReport struct {
+	Tickers       []string
+	Correlation   [][]float64
+	EffectiveBets float64
+	Concentration []concentration
+}
+
+func (r correlationReport) printHuman() {
+	s := fmt.Sprintf("%-6s ", "")
+	for _, t := range r.Tickers {
+		s += fmt.Sprintf("%6s ", t)
+	}
+	fmt.Println(s)
+
+	for i, row := range r.Correlation {
+		s := fmt.Sprintf("%-6s ", r.Tickers[i])
+		for _, c := range row {
+			s += fmt.Sprintf("%6.2f ", c)
+		}
+		fmt.Println(s)
+	}
+
+	fmt.Println("--")
+	fmt.Printf("effective number of independent bets: %.1f (of %d)\n", r.EffectiveBets, len(r.Tickers))
+
+	fmt.Println("top concentrations (weight, share of risk):")
+	for i, c := range r.Concentration {
+		if i == 5 {
+			break
+		}
+		fmt.Printf("  %-6s %5.1f%% %5.1f%%\n", c.Ticker, c.Weight, c.RiskShare)
+	}
+}
+
+func (r correlationReport) printTable() {
+	s := "ticker"
+	for _, t := range r.Tickers {
+		s += ", " + t
+	}
+	fmt.Println(s)
+
+	for i, row := range r.Correlation {
+		s := r.Tickers[i]
+		for _, c := range row {
+			s += fmt.Sprintf(", %.2f", c)
+		}
+		fmt.Println(s)
+	}
+}
+
+// AnalyzeCorrelation prints pairwise correlations of the open positions returns,
+// and how much they actually diversify the portfolio
+func (p *Portfolio) AnalyzeCorrelation(start, end time.Time, period, format string) {
+	p.Collect(end)
+
+	var inss []schema.Instrument
+	var values []float64
+	total := 0.0
+
+	p.forSortedPositions(func(pinfo *schema.PositionInfo) {
+		if pinfo.IsClosed() || pinfo.Ins.Figi == schema.FigiUSD {
+			return
+		}
+		value := -pinfo.OpenDeal.Value() * p.cc.Xchgrate(pinfo.Ins.Currency, "RUB", end)
+
+		inss = append(inss, pinfo.Ins)
+		values = append(values, value)
+		total += value
+	})
+
+	if len(inss) < 2 || total <= 0 {
+		log.Info("Not enough open positions")
+		return
+	}
+
+	cc := candles.NewCandleCache(p.client).WithPeriod(start, period)
+
+	var times []time.Time
+	for _, t := range cc.ListTimes() {
+		if !t.After(end) {
+			times = append(times, t)
+		}
+	}
+
+	if len(times) < 3 {
+		log.Info("Not enough data for this period")
+		return
+	}
+
+	returns := make([][]float64, len(inss))
+	for i, ins := range inss {
+		prev := cc.GetInCurrency(ins, "RUB", times[0])
+		for _, t := range times[1:] {
+			price := cc.GetInCurrency(ins, "RUB", t)
+			returns[i] = append(returns[i], price/prev-1)
+			prev = price
+		}
+	}
+
+	var tickers []string
+	weights := make([]float64, len(inss))
+	for i := range inss {
+		tickers = append(tickers, inss[i].Ticker)
+		weights[i] = values[i] / total
+	}
+
+	printReport(newCorrelationReport(tickers, weights, returns), format)
+}
+
+// newCorrelationReport finds how much the positions of the weights (fractions of 1)
+// with the period returns diversify each other
+func newCorrelationReport(tickers []string, weights []float64, returns [][]float64) correlationReport {
+	n := len(tickers)
+	r := correlationReport{
+		Tickers:     tickers,
+		Correlation: make([][]float64, n),
+	}
+
+	cov := make([][]float64, n)
+	for i := range tickers {
+		r.Correlation[i] = make([]float64, n)
+		cov[i] = make([]float64, n)
+		for j := range tickers {
+			r.Correlation[i][j] = aux.Correlation(returns[i], returns[j])
+			cov[i][j] = aux.Covariance(returns[i], returns[j])
+		}
+	}
+
+	// diversification ratio: weighted volatilities over the portfolio volatility,
+	// its square is the effective number of independent bets
+	variance, weightedVol := 0.0, 0.0
+	marginal := make([]float64, n)
+	for i := range tickers {
+		weightedVol += weights[i] * math.Sqrt(cov[i][i])
+		for j := range tickers {
+			marginal[i] += cov[i][j] * weights[j]
+		}
+		variance += weights[i] * marginal[i]
+	}
+
+	if variance > 0 {
+		r.EffectiveBets = weightedVol * weightedVol / variance
+	}
+
+	for i := range tickers {
+		c := concentration{
+			Ticker: tickers[i],
+			Weight: weights[i] * 100,
+		}
+		if variance > 0 {
+			c.RiskShare = weights[i] * marginal[i] / variance * 100
+		}
+		r.Concentration = append(r.Concentration, c)
+	}
+	sort.Slice(r.Concentration, func(i, j int) bool {
+		return r.Concentration[i].RiskShare > r.Concentration[j].RiskShare
+	})
+
+	return r
+}
@@ -0,0 +1,64 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCorrelationReport(t *testing.T) {
+	// zero mean and orthogonal, so uncorrelated and of the same volatility
+	a := []float64{0.01, -0.01, 0.01, -0.01}
+	b := []float64{0.01, 0.01, -0.01, -0.01}
+	c := []float64{0.01, -0.01, -0.01, 0.01}
+	scale := func(returns []float64, m float64) (scaled []float64) {
+		for _, r := range returns {
+			scaled = append(scaled, r*m)
+		}
+		return
+	}
+
+	tests := []struct {
+		name    string
+		weights []float64
+		returns [][]float64
+		bets    float64
+		shares  []float64 // %, by the risk share descending
+	}{
+		{
+			name:    "uncorrelated equal bets",
+			weights: []float64{1. / 3, 1. / 3, 1. / 3},
+			returns: [][]float64{a, b, c},
+			bets:    3,
+			shares:  []float64{100. / 3, 100. / 3, 100. / 3},
+		},
+		{
+			name:    "the same bet",
+			weights: []float64{0.5, 0.5},
+			returns: [][]float64{a, scale(a, 2)},
+			bets:    1,
+			shares:  []float64{200. / 3, 100. / 3},
+		},
+		{
+			name:    "uncorrelated, twice as volatile",
+			weights: []float64{0.5, 0.5},
+			returns: [][]float64{a, scale(b, 2)},
+			bets:    1.8, // (0.5 + 1)^2 / (0.25 + 1)
+			shares:  []float64{80, 20},
+		},
+	}
+
+	for _, tt := range tests {
+		tickers := []string{"A", "B", "C"}[:len(tt.weights)]
+		r := newCorrelationReport(tickers, tt.weights, tt.returns)
+
+		if math.Abs(r.EffectiveBets-tt.bets) > 1e-9 {
+			t.Errorf("%s: got %.3f effective bets, expected %.3f", tt.name, r.EffectiveBets, tt.bets)
+		}
+		for i, share := range tt.shares {
+			if math.Abs(r.Concentration[i].RiskShare-share) > 1e-9 {
+				t.Errorf("%s: %s has %.2f%% of the risk, expected %.2f%%",
+					tt.name, r.Concentration[i].Ticker, r.Concentration[i].RiskShare, share)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package portfolio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"../aux"
+	"../schema"
+)
+
+// DealsFilter selects, groups and sorts the operations listed by ListDeals
+type DealsFilter struct {
+	Tickers   []string
+	Types     []string
+	Currency  string
+	MinAmount float64 // of the payment, in its currency
+
+	GroupBy string // ticker|type|month
+	SortBy  string // date|amount|ticker
+	Format  string
+}
+
+type dealRow struct {
+	op   schema.Operation
+	tags []string
+}
+
+type dealGroup struct {
+	Key        string
+	Operations []schema.Operation
+	Totals     map[string]float64 // by currency
+}
+
+func (f DealsFilter) matches(op schema.Operation) bool {
+	if len(f.Tickers) > 0 && !aux.IsIn(op.Ticker, f.Tickers...) {
+		return false
+	}
+	if len(f.Types) > 0 && !aux.IsIn(op.OperationType, f.Types...) {
+		return false
+	}
+	if f.Currency != "" && op.Currency != f.Currency {
+		return false
+	}
+	if math.Abs(op.Payment) < f.MinAmount {
+		return false
+	}
+	return true
+}
+
+func (f DealsFilter) sort(rows []dealRow) {
+	switch f.SortBy {
+	case "amount":
+		sort.SliceStable(rows, func(i, j int) bool {
+			return math.Abs(rows[i].op.Payment) > math.Abs(rows[j].op.Payment)
+		})
+	case "ticker":
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].op.Ticker < rows[j].op.Ticker
+		})
+	case "", "date":
+		// already
+	default:
+		log.Fatalf("unknown sort %s", f.SortBy)
+	}
+}
+
+func (f DealsFilter) groupKey(op schema.Operation) string {
+	switch f.GroupBy {
+	case "ticker":
+		if op.Ticker == "" {
+			return "-"
+		}
+		return op.Ticker
+	case "type":
+		return op.OperationType
+	case "month":
+		return op.DateParsed.Format("2006/01")
+	case "":
+		return ""
+	}
+	log.Fatalf("unknown grouping %s", f.GroupBy)
+	return ""
+}
+
+func (f DealsFilter) group(rows []dealRow) (groups []*dealGroup) {
+	byKey := make(map[string]*dealGroup)
+
+	for _, row := range rows {
+		key := f.groupKey(row.op)
+		g := byKey[key]
+		if g == nil {
+			g = &dealGroup{
+				Key:    key,
+				Totals: make(map[string]float64),
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.Operations = append(g.Operations, row.op)
+		g.Totals[row.op.Currency] += row.op.Payment
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+
+	return
+}
+
+func (g dealGroup) totalsString() string {
+	s := ""
+	for _, c := range schema.CurrenciesOrdered {
+		if v, ok := g.Totals[c]; ok {
+			if s != "" {
+				s += ", "
+			}
+			s += fmt.Sprintf("{%s: %.2f}", c, v)
+		}
+	}
+	return s
+}
+
+func printDealsTable(groups []*dealGroup) {
+	fmt.Println("group, date, type, ticker, price, quantity, currency, payment, account, tags")
+	for _, g := range groups {
+		for _, op := range g.Operations {
+			fmt.Printf("%s, %s, %s, %s, %.2f, %d, %s, %.2f, %s, %s\n",
+				g.Key, op.DateParsed.Format("2006/01/02"), op.OperationType, op.Ticker,
+				op.Price, op.Quantity(), op.Currency, op.Payment, op.Account,
+				strings.Join(op.Tags, " "))
+		}
+	}
+}
+
+func printDealsJson(groups []*dealGroup) {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,137 @@
+package portfolio
+
+import (
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestDealsFilterMatches(t *testing.T) {
+	op := schema.Operation{
+		Ticker:        "SBER",
+		OperationType: "Buy",
+		Currency:      "RUB",
+		Payment:       -1500,
+	}
+
+	tests := []struct {
+		name   string
+		filter DealsFilter
+		exp    bool
+	}{
+		{"no filter", DealsFilter{}, true},
+		{"ticker", DealsFilter{Tickers: []string{"GAZP", "SBER"}}, true},
+		{"other ticker", DealsFilter{Tickers: []string{"GAZP"}}, false},
+		{"type", DealsFilter{Types: []string{"Buy", "Sell"}}, true},
+		{"other type", DealsFilter{Types: []string{"Dividend"}}, false},
+		{"currency", DealsFilter{Currency: "RUB"}, true},
+		{"other currency", DealsFilter{Currency: "USD"}, false},
+		{"min amount of a negative payment", DealsFilter{MinAmount: 1500}, true},
+		{"under the min amount", DealsFilter{MinAmount: 1500.01}, false},
+		{"all of them", DealsFilter{Tickers: []string{"SBER"}, Types: []string{"Buy"}, Currency: "RUB",
+			MinAmount: 1000}, true},
+		{"all but one", DealsFilter{Tickers: []string{"SBER"}, Types: []string{"Buy"}, Currency: "USD",
+			MinAmount: 1000}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.matches(op); got != tt.exp {
+			t.Errorf("%s: got %t, expected %t", tt.name, got, tt.exp)
+		}
+	}
+}
+
+func TestDealsFilterGroup(t *testing.T) {
+	row := func(month time.Month, ticker, typ, currency string, payment float64) dealRow {
+		return dealRow{op: schema.Operation{
+			DateParsed:    time.Date(2021, month, 1, 0, 0, 0, 0, time.UTC),
+			Ticker:        ticker,
+			OperationType: typ,
+			Currency:      currency,
+			Payment:       payment,
+		}}
+	}
+	rows := []dealRow{
+		row(2, "SBER", "Buy", "RUB", -300),
+		row(1, "AAPL", "Buy", "USD", -100),
+		row(2, "", "PayIn", "RUB", 1000),
+		row(3, "SBER", "Sell", "RUB", 200),
+	}
+
+	tests := []struct {
+		groupBy, sortBy string
+		keys            []string
+		first           []string // the tickers of the first group, in order
+		totals          map[string]float64
+	}{
+		{
+			groupBy: "ticker",
+			keys:    []string{"-", "AAPL", "SBER"},
+			first:   []string{""},
+			totals:  map[string]float64{"RUB": 1000},
+		},
+		{
+			groupBy: "type",
+			sortBy:  "amount",
+			keys:    []string{"Buy", "PayIn", "Sell"},
+			first:   []string{"SBER", "AAPL"},
+			totals:  map[string]float64{"RUB": -300, "USD": -100},
+		},
+		{
+			groupBy: "month",
+			sortBy:  "ticker",
+			keys:    []string{"2021/01", "2021/02", "2021/03"},
+			first:   []string{"AAPL"},
+			totals:  map[string]float64{"USD": -100},
+		},
+		{
+			sortBy: "ticker",
+			keys:   []string{""},
+			first:  []string{"", "AAPL", "SBER", "SBER"},
+			totals: map[string]float64{"RUB": 900, "USD": -100},
+		},
+	}
+
+	for _, tt := range tests {
+		f := DealsFilter{GroupBy: tt.groupBy, SortBy: tt.sortBy}
+		sorted := append([]dealRow{}, rows...)
+		f.sort(sorted)
+		groups := f.group(sorted)
+
+		name := tt.groupBy + "/" + tt.sortBy
+		if len(groups) != len(tt.keys) {
+			t.Errorf("%s: got %d groups, expected %d", name, len(groups), len(tt.keys))
+			continue
+		}
+		for i, key := range tt.keys {
+			if groups[i].Key != key {
+				t.Errorf("%s: group %d is %s, expected %s", name, i, groups[i].Key, key)
+			}
+		}
+
+		first := groups[0]
+		var tickers []string
+		for _, op := range first.Operations {
+			tickers = append(tickers, op.Ticker)
+		}
+		if len(tickers) != len(tt.first) {
+			t.Errorf("%s: got %v in the first group, expected %v", name, tickers, tt.first)
+			continue
+		}
+		for i := range tickers {
+			if tickers[i] != tt.first[i] {
+				t.Errorf("%s: got %v in the first group, expected %v", name, tickers, tt.first)
+				break
+			}
+		}
+		if len(first.Totals) != len(tt.totals) {
+			t.Errorf("%s: got totals %v, expected %v", name, first.Totals, tt.totals)
+		}
+		for cur, v := range tt.totals {
+			if first.Totals[cur] != v {
+				t.Errorf("%s: got totals %v, expected %v", name, first.Totals, tt.totals)
+			}
+		}
+	}
+}
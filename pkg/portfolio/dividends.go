@@ -0,0 +1,229 @@
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"../aux"
+	"../schema"
+)
+
+type dividendYear struct {
+	Year     int
+	Gross    float64
+	Tax      float64 // positive
+	Net      float64
+	PerShare float64 // gross
+	Growth   float64 // % of the previous year per share, 0 if none
+	YoC      float64 // %, per share gross to the average cost at the end of the year
+}
+
+type dividendPosition struct {
+	Ticker   string
+	Currency string
+	Years    []*dividendYear
+
+	YoC          float64 // %, trailing year
+	CurrentYield float64 // %, trailing year
+}
+
+type taxDrag struct {
+	Currency   string
+	Gross, Tax float64
+	Drag       float64 // %
+}
+
+type dividendReport struct {
+	Positions []*dividendPosition
+	TaxDrag   []*taxDrag
+}
+
+// costAt is the average cost per unit of the long position held just before t,
+// commission included. Once it is closed, it is the cost of the units sold last
+func costAt(pinfo *schema.PositionInfo, t time.Time) float64 {
+	cost, last := 0.0, 0.0
+	q := 0
+	for _, deal := range pinfo.Deals {
+		if !deal.Date.Before(t) {
+			break
+		}
+
+		switch {
+		case deal.Quantity > 0 && q >= 0:
+			cost += deal.Expense()
+		case deal.Quantity > 0 && q+deal.Quantity > 0:
+			// the short is covered, the rest is long
+			cost = deal.Expense() * float64(q+deal.Quantity) / float64(deal.Quantity)
+		case deal.Quantity < 0 && q > 0:
+			// no more than the long part is sold
+			sold := -deal.Quantity
+			if sold > q {
+				sold = q
+			}
+			cost -= cost / float64(q) * float64(sold)
+		}
+		q += deal.Quantity
+
+		if q > 0 {
+			last = cost / float64(q)
+		} else {
+			cost = 0
+		}
+	}
+	return last
+}
+
+// taxes come separately, usually the same day;
+// they are counted for the year of the payment they belong to
+func taxDate(divs []schema.Dividend, tax schema.Dividend) time.Time {
+	date := tax.Date
+	for _, div := range divs {
+		if div.IsTax() || div.Date.After(tax.Date) {
+			continue
+		}
+		if tax.Date.Sub(div.Date) < 7*24*time.Hour {
+			date = div.Date
+		}
+	}
+	return date
+}
+
+func (p *Portfolio) dividendPosition(pinfo *schema.PositionInfo, at time.Time) *dividendPosition {
+	dp := &dividendPosition{
+		Ticker:   pinfo.Ins.Ticker,
+		Currency: pinfo.Ins.Currency,
+	}
+
+	years := make(map[int]*dividendYear)
+	year := func(t time.Time) *dividendYear {
+		if years[t.Year()] == nil {
+			years[t.Year()] = &dividendYear{Year: t.Year()}
+		}
+		return years[t.Year()]
+	}
+
+	trailing := 0.0 // per share
+	for _, div := range pinfo.Dividends {
+		if div.Type == "PartRepayment" {
+			continue
+		}
+
+		if div.IsTax() {
+			year(taxDate(pinfo.Dividends, div)).Tax -= div.Value
+			continue
+		}
+
+		dy := year(div.Date)
+		dy.Gross += div.Value
+
+		// it is the record date that matters, but the payment comes later
+		if q := pinfo.QuantityAt(div.Date); q > 0 {
+			dy.PerShare += div.Value / float64(q)
+			if at.Sub(div.Date) < 365*24*time.Hour {
+				trailing += div.Value / float64(q)
+			}
+		}
+	}
+
+	for _, dy := range years {
+		dy.Net = dy.Gross - dy.Tax
+
+		end := time.Date(dy.Year+1, 1, 1, 0, 0, 0, 0, at.Location())
+		if end.After(at) {
+			end = at
+		}
+		if cost := costAt(pinfo, end); cost != 0 {
+			dy.YoC = 100 * dy.PerShare / cost
+		}
+		if prev := years[dy.Year-1]; prev != nil && prev.PerShare != 0 {
+			dy.Growth = aux.Ratio2Perc(dy.PerShare / prev.PerShare)
+		}
+		dp.Years = append(dp.Years, dy)
+	}
+	sort.Slice(dp.Years, func(i, j int) bool {
+		return dp.Years[i].Year < dp.Years[j].Year
+	})
+
+	if cost := costAt(pinfo, at); cost != 0 {
+		dp.YoC = 100 * trailing / cost
+	}
+	if price := p.cc.Get(pinfo.Ins.Figi, at); price != 0 && !pinfo.IsClosed() {
+		dp.CurrentYield = 100 * trailing / price
+	}
+
+	return dp
+}
+
+func (r dividendReport) printHuman() {
+	fmt.Println("== Dividends ==")
+	for _, dp := range r.Positions {
+		fmt.Printf("%-6s (%s): yield on cost %5.1f%%, current yield %5.1f%%\n",
+			dp.Ticker, dp.Currency, dp.YoC, dp.CurrentYield)
+
+		for _, dy := range dp.Years {
+			s := fmt.Sprintf("  %d: gross %9.2f, tax %8.2f, net %9.2f, per share %7.2f, yield on cost %5.1f%%",
+				dy.Year, dy.Gross, dy.Tax, dy.Net, dy.PerShare, dy.YoC)
+			if dy.Growth != 0 {
+				s += fmt.Sprintf(", growth %+.1f%%", dy.Growth)
+			}
+			fmt.Println(s)
+		}
+	}
+
+	fmt.Println("== Tax drag ==")
+	for _, td := range r.TaxDrag {
+		fmt.Printf("%s: gross %.2f, tax %.2f (%.1f%%)\n", td.Currency, td.Gross, td.Tax, td.Drag)
+	}
+}
+
+func (r dividendReport) printTable() {
+	fmt.Println("ticker, currency, year, gross, tax, net, per.share, growth, yoc")
+	for _, dp := range r.Positions {
+		for _, dy := range dp.Years {
+			fmt.Printf("%s, %s, %d, %.2f, %.2f, %.2f, %.4f, %.1f, %.1f\n",
+				dp.Ticker, dp.Currency, dy.Year,
+				dy.Gross, dy.Tax, dy.Net, dy.PerShare, dy.Growth, dy.YoC)
+		}
+	}
+}
+
+// Dividends prints the income of every position by years,
+// how it grows, and how much of it is taken by taxes
+func (p *Portfolio) Dividends(at time.Time, format string) {
+	p.Collect(at)
+
+	var r dividendReport
+	drags := make(map[string]*taxDrag)
+
+	p.forSortedPositions(func(pinfo *schema.PositionInfo) {
+		if len(pinfo.Dividends) == 0 {
+			return
+		}
+
+		dp := p.dividendPosition(pinfo, at)
+		if len(dp.Years) == 0 {
+			return
+		}
+		r.Positions = append(r.Positions, dp)
+
+		if drags[dp.Currency] == nil {
+			drags[dp.Currency] = &taxDrag{Currency: dp.Currency}
+		}
+		for _, dy := range dp.Years {
+			drags[dp.Currency].Gross += dy.Gross
+			drags[dp.Currency].Tax += dy.Tax
+		}
+	})
+
+	for _, cur := range schema.CurrenciesOrdered {
+		if td := drags[cur]; td != nil {
+			if td.Gross != 0 {
+				td.Drag = 100 * td.Tax / td.Gross
+			}
+			r.TaxDrag = append(r.TaxDrag, td)
+		}
+	}
+
+	printReport(r, format)
+}
@@ -0,0 +1,46 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestCostAt(t *testing.T) {
+	day := func(y, d int) time.Time {
+		return time.Date(y, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	deal := func(t time.Time, quantity int, price, commission float64) schema.Deal {
+		return schema.Deal{Date: t, Price: schema.NewCValue(price, "RUB"), Quantity: quantity, Commission: commission}
+	}
+
+	pinfo := &schema.PositionInfo{Deals: []schema.Deal{
+		deal(day(2019, 10), 10, 100, -10),
+		deal(day(2020, 10), 10, 120, 0),
+		deal(day(2020, 20), -5, 130, 0),
+		deal(day(2021, 10), -15, 140, 0),
+		deal(day(2022, 10), -10, 150, 0),
+		deal(day(2022, 20), 15, 160, 0),
+	}}
+
+	tests := []struct {
+		at   time.Time
+		cost float64
+	}{
+		{day(2019, 1), 0},
+		{day(2020, 1), 101},
+		{day(2021, 1), 110.5},
+		// closed, the cost of the units sold last
+		{day(2022, 1), 110.5},
+		// the short is covered, the rest is long
+		{day(2023, 1), 160},
+	}
+
+	for _, tt := range tests {
+		if cost := costAt(pinfo, tt.at); math.Abs(cost-tt.cost) > 1e-9 {
+			t.Errorf("%s: cost %.2f, expected %.2f", tt.at.Format("2006/01/02"), cost, tt.cost)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"../candles"
+	"../schema"
+)
+
+// Tariff is a broker tariff to compare with.
+// Exchange and margin commissions are kept as they are
+type Tariff struct {
+	Name    string
+	Broker  float64 // % of the turnover
+	Service float64 // RUB for every month with deals
+}
+
+func readTariffs(fname string) (tariffs []Tariff) {
+	readJSON(fname, &tariffs)
+
+	return
+}
+
+// feeRow sums commissions in RUB at the rate of their day
+type feeRow struct {
+	Key      string
+	Turnover float64
+
+	Broker, Service, Exchange, Margin, Other float64
+}
+
+func (r feeRow) Total() float64 {
+	return r.Broker + r.Service + r.Exchange + r.Margin + r.Other
+}
+
+// Rate is the turnover-weighted effective rate, %
+func (r feeRow) Rate() float64 {
+	if r.Turnover == 0 {
+		return 0
+	}
+	return 100 * r.Total() / r.Turnover
+}
+
+func (r *feeRow) add(op schema.Operation, value float64) {
+	switch op.OperationType {
+	case "BrokerCommission":
+		r.Broker += value
+	case "ServiceCommission":
+		r.Service += value
+	case "ExchangeCommission":
+		r.Exchange += value
+	case "MarginCommission":
+		r.Margin += value
+	default:
+		r.Other += value
+	}
+}
+
+type feeGroup map[string]*feeRow
+
+func (g feeGroup) row(key string) *feeRow {
+	if g[key] == nil {
+		g[key] = &feeRow{Key: key}
+	}
+	return g[key]
+}
+
+func (g feeGroup) sorted() (rows []*feeRow) {
+	for _, r := range g {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Key < rows[j].Key
+	})
+	return
+}
+
+type tariffEstimate struct {
+	Name  string
+	Total float64
+	Rate  float64 // %
+	Diff  float64 // to the actual ones
+}
+
+type feeReport struct {
+	Total     feeRow
+	Groups    map[string][]*feeRow
+	Estimates []tariffEstimate
+}
+
+var feeGroupsOrdered = []string{"month", "account", "type", "currency"}
+
+func printFeeRowHuman(r feeRow) {
+	fmt.Printf("  %-22s turnover %11.0f, broker %8.0f, service %6.0f, exchange %6.0f, margin %6.0f, other %6.0f = %8.0f (%.3f%%)\n",
+		r.Key, r.Turnover, r.Broker, r.Service, r.Exchange, r.Margin, r.Other, r.Total(), r.Rate())
+}
+
+func printFeeRowTable(group string, r feeRow) {
+	fmt.Printf("%s, %s, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f, %.4f\n",
+		group, r.Key, r.Turnover, r.Broker, r.Service, r.Exchange, r.Margin, r.Other, r.Total(), r.Rate())
+}
+
+func (r feeReport) printHuman() {
+	for _, group := range feeGroupsOrdered {
+		fmt.Printf("== By %s ==\n", group)
+		for _, row := range r.Groups[group] {
+			printFeeRowHuman(*row)
+		}
+	}
+
+	fmt.Println("== Total ==")
+	printFeeRowHuman(r.Total)
+
+	if len(r.Estimates) > 0 {
+		fmt.Println("== Tariffs ==")
+		for _, e := range r.Estimates {
+			fmt.Printf("  %-22s %8.0f (%.3f%%), %+.0f\n", e.Name, e.Total, e.Rate, e.Diff)
+		}
+	}
+}
+
+func (r feeReport) printTable() {
+	fmt.Println("group, key, turnover, broker, service, exchange, margin, other, total, rate")
+	for _, group := range feeGroupsOrdered {
+		for _, row := range r.Groups[group] {
+			printFeeRowTable(group, *row)
+		}
+	}
+	printFeeRowTable("total", r.Total)
+}
+
+// Fees breaks the commissions down by months, accounts, instrument types and currencies,
+// and estimates them for other tariffs
+func (p *Portfolio) Fees(start, end time.Time, format, tariffsFile string) {
+	p.cc = candles.NewCandleCache(p.client)
+	p.data.ops = p.getOperations(start)
+
+	var tariffs []Tariff
+	if tariffsFile != "" {
+		tariffs = readTariffs(tariffsFile)
+	}
+
+	printReport(newFeeReport(p.data.ops, end, p.cc.Xchgrate, tariffs), format)
+}
+
+// newFeeReport sums the turnover and the commissions of ops up to end, RUB at the rate of their day
+func newFeeReport(ops []schema.Operation, end time.Time,
+	xchgrate func(curr_from, curr_to string, t time.Time) float64, tariffs []Tariff) feeReport {
+	groups := make(map[string]feeGroup)
+	for _, group := range feeGroupsOrdered {
+		groups[group] = make(feeGroup)
+	}
+
+	r := feeReport{
+		Total:  feeRow{Key: "total"},
+		Groups: make(map[string][]*feeRow),
+	}
+
+	for _, op := range ops {
+		if op.DateParsed.After(end) {
+			break
+		}
+		if op.Status != "Done" || !op.IsTrading() && !op.IsCommission() {
+			continue
+		}
+
+		keys := map[string]string{
+			"month":    op.DateParsed.Format("2006/01"),
+			"account":  op.Account,
+			"type":     op.InstrumentType,
+			"currency": op.Currency,
+		}
+		if keys["account"] == "" {
+			keys["account"] = "-"
+		}
+		if keys["type"] == "" {
+			keys["type"] = "-"
+		}
+
+		value := math.Abs(op.Payment) * xchgrate(op.Currency, "RUB", op.DateParsed)
+
+		rows := []*feeRow{&r.Total}
+		for group, key := range keys {
+			rows = append(rows, groups[group].row(key))
+		}
+
+		for _, row := range rows {
+			if op.IsTrading() {
+				row.Turnover += value
+			} else {
+				row.add(op, value)
+			}
+		}
+	}
+
+	for group, g := range groups {
+		r.Groups[group] = g.sorted()
+	}
+
+	if len(tariffs) > 0 {
+		months := 0
+		for _, row := range r.Groups["month"] {
+			if row.Turnover != 0 {
+				months++
+			}
+		}
+
+		actual := r.Total.Total()
+		for _, t := range tariffs {
+			e := tariffEstimate{
+				Name: t.Name,
+				Total: r.Total.Turnover*t.Broker/100 + t.Service*float64(months) +
+					r.Total.Exchange + r.Total.Margin + r.Total.Other,
+			}
+			if r.Total.Turnover != 0 {
+				e.Rate = 100 * e.Total / r.Total.Turnover
+			}
+			e.Diff = e.Total - actual
+			r.Estimates = append(r.Estimates, e)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,103 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestFeeReport(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	op := func(t time.Time, typ, acc, insType, currency string, payment float64) schema.Operation {
+		return schema.Operation{
+			DateParsed:     t,
+			OperationType:  typ,
+			Account:        acc,
+			InstrumentType: insType,
+			Currency:       currency,
+			Payment:        payment,
+			Status:         "Done",
+		}
+	}
+	xchgrate := func(curr_from, curr_to string, t time.Time) float64 {
+		if curr_from == "USD" {
+			// the rate of the day
+			return 70 + float64(t.Month())
+		}
+		return 1
+	}
+
+	declined := op(date(2, 8), "BrokerCommission", "1", "Stock", "RUB", -1000)
+	declined.Status = "Decline"
+
+	ops := []schema.Operation{
+		op(date(1, 10), "Buy", "1", "Stock", "RUB", -10000),
+		op(date(1, 10), "BrokerCommission", "1", "Stock", "RUB", -5),
+		op(date(1, 20), "PayIn", "1", "", "RUB", 50000),
+		op(date(1, 31), "ServiceCommission", "1", "", "RUB", -99),
+		op(date(2, 5), "Sell", "2", "Etf", "USD", 100),
+		op(date(2, 5), "BrokerCommission", "2", "Etf", "USD", -0.05),
+		op(date(2, 6), "MarginCommission", "2", "", "RUB", -10),
+		op(date(2, 7), "Buy", "2", "Etf", "USD", -100),
+		declined,
+		// after the end
+		op(date(3, 1), "Buy", "1", "Stock", "RUB", -1000),
+	}
+
+	r := newFeeReport(ops, date(2, 28), xchgrate, []Tariff{{Name: "flat", Broker: 0.1, Service: 100}})
+
+	type row struct {
+		key                             string
+		turnover, broker, service, marg float64
+	}
+	exp := map[string][]row{
+		"month": {
+			{key: "2021/01", turnover: 10000, broker: 5, service: 99},
+			{key: "2021/02", turnover: 14400, broker: 3.6, marg: 10},
+		},
+		"account": {
+			{key: "1", turnover: 10000, broker: 5, service: 99},
+			{key: "2", turnover: 14400, broker: 3.6, marg: 10},
+		},
+		"type": {
+			{key: "-", service: 99, marg: 10},
+			{key: "Etf", turnover: 14400, broker: 3.6},
+			{key: "Stock", turnover: 10000, broker: 5},
+		},
+		"currency": {
+			{key: "RUB", turnover: 10000, broker: 5, service: 99, marg: 10},
+			{key: "USD", turnover: 14400, broker: 3.6},
+		},
+	}
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+	for group, rows := range exp {
+		if len(r.Groups[group]) != len(rows) {
+			t.Errorf("%s: got %d rows, expected %d", group, len(r.Groups[group]), len(rows))
+			continue
+		}
+		for i, e := range rows {
+			got := r.Groups[group][i]
+			if got.Key != e.key || !near(got.Turnover, e.turnover) || !near(got.Broker, e.broker) ||
+				!near(got.Service, e.service) || !near(got.Margin, e.marg) {
+				t.Errorf("%s: got %+v, expected %+v", group, *got, e)
+			}
+		}
+	}
+
+	if !near(r.Total.Total(), 117.6) || !near(r.Total.Turnover, 24400) {
+		t.Errorf("got total %+v", r.Total)
+	}
+
+	// 0.1% of the turnover, 2 months of service and the margin as it is
+	if len(r.Estimates) != 1 || !near(r.Estimates[0].Total, 24.4+200+10) ||
+		!near(r.Estimates[0].Diff, 24.4+200+10-117.6) {
+		t.Errorf("got estimates %+v", r.Estimates)
+	}
+}
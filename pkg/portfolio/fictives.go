@@ -1,9 +1,7 @@
 package portfolio
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"time"
 
@@ -22,19 +20,16 @@ type FictiveDeal struct {
 }
 
 func readFictives(fname string) (ops []FictiveDeal) {
-	data, err := ioutil.ReadFile(fname)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = json.Unmarshal(data, &ops)
-	if err != nil {
-		log.Fatal(err)
-	}
+	readJSON(fname, &ops)
 
 	return
 }
 
+// lotRound returns the number of units closest to amount, rounded to lots
+func lotRound(amount, price float64, lot int) int {
+	return int(math.Round(amount/(price*float64(lot)))) * lot
+}
+
 func fetchFictives(c *client.MyClient, cc *candles.CandleCache, fname string) (ops []schema.Operation) {
 	var totalAmount float64
 
@@ -63,7 +58,7 @@ func fetchFictives(c *client.MyClient, cc *candles.CandleCache, fname string) (o
 		}
 
 		price := cc.Get(ins.Figi, date)
-		n := uint(math.Round(op.Amount/(price*float64(ins.Lot)))) * uint(ins.Lot)
+		n := uint(lotRound(op.Amount, price, ins.Lot))
 		pment := price * float64(n)
 
 		s := fmt.Sprintf("%-5s spent %8.2f/%8.2f - %5.1f%% (%5.1f%% -> %5.1f%%)",
@@ -0,0 +1,123 @@
+package portfolio
+
+import (
+	"fmt"
+	"time"
+
+	"../schema"
+)
+
+// fxFlow is the money put into the USD positions of a section,
+// in USD and in RUB at the rates of the days it was spent
+type fxFlow struct {
+	local, rub float64
+}
+
+type fxAttribution struct {
+	Section  schema.Section
+	Local    float64 // RUB, the USD result at the current rate
+	Currency float64 // RUB, the revaluation of the money put in
+}
+
+func (p *Portfolio) addFxFlow(pinfo *schema.PositionInfo, op schema.Operation, deal schema.Deal, isDeal bool) {
+	if p.fxFlows == nil || pinfo.Ins.Figi == schema.FigiUSD || op.Currency != "USD" {
+		return
+	}
+
+	flow := 0.0
+	if isDeal {
+		flow = deal.Expense()
+	} else if op.IsPayment() {
+		// the income leaves the section for cash
+		flow = -op.Payment
+	} else {
+		return
+	}
+
+	rate := p.cc.Xchgrate(op.Currency, "RUB", op.DateParsed)
+	for section, w := range p.sections.Weights(pinfo.Ins) {
+		if p.fxFlows[section] == nil {
+			p.fxFlows[section] = &fxFlow{}
+		}
+		p.fxFlows[section].local += flow * w
+		p.fxFlows[section].rub += flow * w * rate
+	}
+}
+
+// fxAttributions splits the RUB result of every section holding USD positions:
+// the local part is what the positions earned in USD, converted at the rate of t,
+// the currency part is what the ruble moves did to the money put in
+func (p *Portfolio) fxAttributions(sb schema.SectionedBalance, t time.Time) []fxAttribution {
+	return p.fxAttributionsAt(sb, p.cc.Xchgrate("USD", "RUB", t))
+}
+
+func (p *Portfolio) fxAttributionsAt(sb schema.SectionedBalance, rate float64) (fas []fxAttribution) {
+	for _, section := range p.sections.Ordered {
+		flow := p.fxFlows[section]
+		if flow == nil {
+			continue
+		}
+
+		value := 0.0
+		if bal := sb.Sections[section]; bal != nil {
+			value = bal.Assets["USD"].Value
+		}
+
+		fas = append(fas, fxAttribution{
+			Section:  section,
+			Local:    (value - flow.local) * rate,
+			Currency: flow.local*rate - flow.rub,
+		})
+	}
+	return
+}
+
+func (p *Portfolio) printFx(at time.Time) {
+	fas := p.fxAttributions(p.balance, at)
+	if len(fas) == 0 {
+		return
+	}
+
+	fmt.Println("== Currency attribution ==")
+	for _, fa := range fas {
+		fmt.Printf("%-10s: %8.0f = local %8.0f + currency %8.0f\n",
+			fa.Section, fa.Local+fa.Currency, fa.Local, fa.Currency)
+	}
+}
+
+// fxSections are the sections holding USD positions, in their order
+func (p *Portfolio) fxSections() (sections []schema.Section) {
+	for _, section := range p.sections.Ordered {
+		if p.fxFlows[section] != nil {
+			sections = append(sections, section)
+		}
+	}
+	return
+}
+
+func fxHead(sections []schema.Section) (head []string) {
+	for _, section := range sections {
+		head = append(head, "fx."+string(section)+".local", "fx."+string(section)+".currency")
+	}
+	return
+}
+
+// fxColumns are the attributions of the sections, 0 for those not holding USD yet
+func fxColumns(fas []fxAttribution, sections []schema.Section, style string) string {
+	s := ""
+	for _, section := range sections {
+		var fa fxAttribution
+		for _, a := range fas {
+			if a.Section == section {
+				fa = a
+			}
+		}
+
+		if style == schema.TableStyle {
+			s += fmt.Sprintf(", %.0f, %.0f", fa.Local, fa.Currency)
+		} else if fa.Section != "" {
+			s += fmt.Sprintf(" %s: %.0f + %.0f;", fa.Section, fa.Local, fa.Currency)
+		}
+	}
+	return s
+}
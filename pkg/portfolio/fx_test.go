@@ -0,0 +1,54 @@
+package portfolio
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"../schema"
+)
+
+func TestFxAttributions(t *testing.T) {
+	sections := schema.DefaultSections()
+	p := &Portfolio{sections: sections}
+	p.fxFlows = map[schema.Section]*fxFlow{
+		// 1000 USD spent at 70, 500 more at 80
+		schema.StockUsd: {local: 1500, rub: 1000*70 + 500*80},
+		// the coupons have brought more than was spent
+		schema.BondUsd: {local: -50, rub: -50 * 75},
+	}
+
+	sb := schema.NewSectionedBalance(sections.Ordered)
+	sb.SectionBalance(schema.StockUsd).Assets["USD"].Value = 1800
+
+	rate := 90.0
+	fas := p.fxAttributionsAt(sb, rate)
+	if len(fas) != 2 {
+		t.Fatalf("%d attributions, expected 2", len(fas))
+	}
+
+	for _, fa := range fas {
+		flow := p.fxFlows[fa.Section]
+		value := sb.SectionBalance(fa.Section).Assets["USD"].Value
+		// the whole RUB result: the value now less the rubles put in
+		if result := value*rate - flow.rub; math.Abs(fa.Local+fa.Currency-result) > 1e-9 {
+			t.Errorf("%s: local %.2f + currency %.2f, expected %.2f", fa.Section, fa.Local, fa.Currency, result)
+		}
+	}
+
+	stocks := fas[1]
+	if fas[0].Section != schema.BondUsd || stocks.Section != schema.StockUsd {
+		t.Fatalf("sections are out of order: %s, %s", fas[0].Section, stocks.Section)
+	}
+	if stocks.Local != 300*90 || stocks.Currency != 1000*20+500*10 {
+		t.Errorf("%s: local %.2f, currency %.2f", stocks.Section, stocks.Local, stocks.Currency)
+	}
+
+	head := fxHead(p.fxSections())
+	if strings.Join(head, ", ") != "fx.Bond.USD.local, fx.Bond.USD.currency, fx.Stock.USD.local, fx.Stock.USD.currency" {
+		t.Errorf("head %v", head)
+	}
+	if cols := fxColumns(fas[1:], p.fxSections(), schema.TableStyle); cols != ", 0, 0, 27000, 25000" {
+		t.Errorf("columns %q", cols)
+	}
+}
@@ -0,0 +1,133 @@
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"../schema"
+)
+
+const (
+	iisLimit        = 1000000 // RUB per year
+	iisLimitOld     = 400000  // before 2017
+	iisDeductionCap = 400000  // RUB per year of contributions
+	iisTaxRate      = 0.13
+	iisHoldingYears = 3
+)
+
+type iisYear struct {
+	year      int
+	payins    float64
+	deduction float64
+}
+
+func iisYearLimit(year int) float64 {
+	if year < 2017 {
+		return iisLimitOld
+	}
+	return iisLimit
+}
+
+// iisYears sums the payins of ops by years up to at, with the deductions for them.
+// The account is opened by the first operation
+func iisYears(ops []schema.Operation, at time.Time,
+	xchgrate func(curr_from, curr_to string, t time.Time) float64) (opened time.Time, sorted []*iisYear, totalDeduction float64) {
+	years := make(map[int]*iisYear)
+
+	for _, op := range ops {
+		if op.Status != "Done" || op.DateParsed.After(at) {
+			continue
+		}
+		if opened.IsZero() {
+			opened = op.DateParsed
+		}
+		if op.Kind() != schema.OpPayIn && op.Kind() != schema.OpPayOut {
+			continue
+		}
+
+		y := op.DateParsed.Year()
+		if years[y] == nil {
+			years[y] = &iisYear{year: y}
+		}
+		years[y].payins += op.Payment * xchgrate(op.Currency, "RUB", op.DateParsed)
+	}
+
+	for _, y := range years {
+		base := y.payins
+		if base > iisDeductionCap {
+			base = iisDeductionCap
+		}
+		if base > 0 {
+			y.deduction = base * iisTaxRate
+		}
+		totalDeduction += y.deduction
+		sorted = append(sorted, y)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].year < sorted[j].year
+	})
+
+	return
+}
+
+// iisDeadline is since when the account can be closed keeping the benefits
+func iisDeadline(opened time.Time) time.Time {
+	return opened.AddDate(iisHoldingYears, 0, 0)
+}
+
+// Iis tracks contributions to the individual investment account against the limit,
+// the type A deduction and the type B tax exemption
+func (p *Portfolio) Iis(at time.Time, format string) {
+	p.Collect(at)
+
+	opened, sorted, totalDeduction := iisYears(p.data.ops, at, p.cc.Xchgrate)
+	if opened.IsZero() {
+		fmt.Println("No IIS operations")
+		return
+	}
+
+	deadline := iisDeadline(opened)
+	gains := p.assets() - p.payins()
+	exemption := 0.0
+	if gains > 0 {
+		exemption = gains * iisTaxRate
+	}
+
+	if format == schema.TableStyle {
+		fmt.Println("year, payins, limit, left, deduction")
+		for _, y := range sorted {
+			limit := iisYearLimit(y.year)
+			fmt.Printf("%d, %.0f, %.0f, %.0f, %.0f\n", y.year, y.payins, limit, limit-y.payins, y.deduction)
+		}
+		return
+	}
+
+	fmt.Println("== IIS ==")
+	fmt.Printf("opened %s, can be closed without losing the benefits since %s",
+		opened.Format("2006/01/02"), deadline.Format("2006/01/02"))
+	if at.Before(deadline) {
+		fmt.Printf(" (%d days left)", int(deadline.Sub(at).Hours()/24)+1)
+	}
+	fmt.Println()
+
+	for _, y := range sorted {
+		limit := iisYearLimit(y.year)
+		s := fmt.Sprintf("  %d: payins %8.0f of %8.0f", y.year, y.payins, limit)
+		if y.payins > limit {
+			s += " (over the limit!)"
+		} else if y.year == at.Year() {
+			s += fmt.Sprintf(" (%.0f left)", limit-y.payins)
+		}
+		s += fmt.Sprintf(", type A deduction %6.0f", y.deduction)
+		fmt.Println(s)
+	}
+
+	fmt.Printf("type A: %.0f of deductions (as long as enough income tax is paid)\n", totalDeduction)
+	fmt.Printf("type B: %.0f of gains, %.0f of tax exempt\n", gains, exemption)
+	if totalDeduction >= exemption {
+		fmt.Printf("type A is better by %.0f so far\n", totalDeduction-exemption)
+	} else {
+		fmt.Printf("type B is better by %.0f so far\n", exemption-totalDeduction)
+	}
+}
@@ -0,0 +1,79 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestIisYearLimit(t *testing.T) {
+	for year, exp := range map[int]float64{2015: 400000, 2016: 400000, 2017: 1000000, 2021: 1000000} {
+		if got := iisYearLimit(year); got != exp {
+			t.Errorf("%d: got %.0f, expected %.0f", year, got, exp)
+		}
+	}
+}
+
+func TestIisYears(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	op := func(t time.Time, typ, currency string, payment float64) schema.Operation {
+		return schema.Operation{
+			DateParsed:    t,
+			OperationType: typ,
+			Currency:      currency,
+			Payment:       payment,
+			Status:        "Done",
+		}
+	}
+	xchgrate := func(curr_from, curr_to string, t time.Time) float64 {
+		if curr_from == "USD" {
+			return 70
+		}
+		return 1
+	}
+
+	declined := op(date(2018, 2, 1), "PayIn", "RUB", 1000)
+	declined.Status = "Decline"
+
+	ops := []schema.Operation{
+		declined,
+		op(date(2018, 3, 15), "ServiceCommission", "RUB", -99),
+		op(date(2018, 3, 16), "PayIn", "RUB", 100000),
+		op(date(2018, 12, 1), "PayIn", "USD", 1000), // 70000 RUB
+		op(date(2019, 5, 1), "PayIn", "RUB", 1000000),
+		op(date(2020, 5, 1), "PayIn", "RUB", 50000),
+		op(date(2020, 6, 1), "PayOut", "RUB", -80000),
+		op(date(2021, 5, 1), "PayIn", "RUB", 400000), // after at
+	}
+
+	opened, years, total := iisYears(ops, date(2021, 1, 1), xchgrate)
+
+	if !opened.Equal(date(2018, 3, 15)) {
+		t.Errorf("opened %s, expected %s", opened, date(2018, 3, 15))
+	}
+	if deadline := iisDeadline(opened); !deadline.Equal(date(2021, 3, 15)) {
+		t.Errorf("deadline %s, expected %s", deadline, date(2021, 3, 15))
+	}
+
+	expected := []iisYear{
+		{year: 2018, payins: 170000, deduction: 22100},
+		{year: 2019, payins: 1000000, deduction: 52000}, // capped at 400k
+		{year: 2020, payins: -30000, deduction: 0},
+	}
+	if len(years) != len(expected) {
+		t.Fatalf("got %d years, expected %d", len(years), len(expected))
+	}
+	for i, e := range expected {
+		y := years[i]
+		if y.year != e.year || math.Abs(y.payins-e.payins) > 1e-6 || math.Abs(y.deduction-e.deduction) > 1e-6 {
+			t.Errorf("got %+v, expected %+v", *y, e)
+		}
+	}
+	if math.Abs(total-74100) > 1e-6 {
+		t.Errorf("got %.0f of deductions, expected 74100", total)
+	}
+}
@@ -0,0 +1,328 @@
+package portfolio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// ImportMapping tells where the operation fields are in a statement.
+// Columns values are the header names, Types map the statement operation names
+// to the schema ones (Buy, Sell, PayIn, Dividend etc)
+type ImportMapping struct {
+	Separator  string
+	DateFormat string
+	Currency   string // when there is no currency column
+	Columns    struct {
+		Date, Time, Type                               string
+		Ticker, Figi                                   string
+		Quantity, Price, Payment, Commission, Currency string
+		Accrued                                        string // bonds, not included in Payment
+	}
+	Types map[string]string
+}
+
+// the trades table of the broker report
+var brokerMapping = func() (m ImportMapping) {
+	m.Separator = ";"
+	m.DateFormat = "02.01.2006"
+	m.Columns.Date = "Дата заключения"
+	m.Columns.Time = "Время"
+	m.Columns.Type = "Вид сделки"
+	m.Columns.Ticker = "Код актива"
+	m.Columns.Quantity = "Количество"
+	m.Columns.Price = "Цена за единицу"
+	m.Columns.Payment = "Сумма сделки"
+	m.Columns.Commission = "Комиссия брокера"
+	m.Columns.Accrued = "НКД"
+	m.Columns.Currency = "Валюта цены"
+	m.Types = map[string]string{
+		"Покупка": "Buy",
+		"Продажа": "Sell",
+	}
+	return
+}()
+
+func readImportMapping(fname string) ImportMapping {
+	if fname == "" {
+		return brokerMapping
+	}
+
+	var m ImportMapping
+	readJSON(fname, &m)
+
+	return m
+}
+
+func readCsv(fname, separator string) [][]string {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if separator != "" {
+		r.Comma = []rune(separator)[0]
+	}
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rows
+}
+
+// "1 234,56" -> 1234.56
+func parseStatementNumber(s string) (float64, error) {
+	s = strings.NewReplacer(" ", "", "\u00a0", "", ",", ".").Replace(s)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// the broker keeps the statements in the Moscow time
+var moscow = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.FixedZone("MSK", 3*60*60)
+	}
+	return loc
+}()
+
+func parseStatementDate(date, clock, format string) (time.Time, error) {
+	if serial, err := strconv.ParseFloat(date, 64); err == nil {
+		// xlsx keeps dates as days since 1899/12/30
+		t := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+		t = t.Add(time.Duration(serial * 24 * float64(time.Hour)))
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, moscow), nil
+	}
+
+	if clock != "" {
+		return time.ParseInLocation(format+" 15:04:05", date+" "+clock, moscow)
+	}
+	return time.ParseInLocation(format, date, moscow)
+}
+
+type statementRow map[string]string
+
+func (m ImportMapping) makeOperation(p *Portfolio, row statementRow) (op schema.Operation, err error) {
+	cols := m.Columns
+
+	date, err := parseStatementDate(row[cols.Date], row[cols.Time], m.DateFormat)
+	if err != nil {
+		return op, err
+	}
+
+	typ, ok := m.Types[row[cols.Type]]
+	if !ok {
+		typ = row[cols.Type]
+	}
+	if !schema.IsKnownOperation(typ) {
+		return op, fmt.Errorf("unknown operation type %s", row[cols.Type])
+	}
+
+	var nums [5]float64
+	for i, col := range []string{cols.Quantity, cols.Price, cols.Payment, cols.Commission, cols.Accrued} {
+		if nums[i], err = parseStatementNumber(row[col]); err != nil {
+			return op, err
+		}
+	}
+	quantity, price, payment, commission, accrued := nums[0], nums[1], nums[2], nums[3], nums[4]
+
+	op = schema.Operation{
+		Date:          date.Format(time.RFC3339),
+		DateParsed:    date,
+		OperationType: typ,
+		Status:        "Done",
+		Currency:      row[cols.Currency],
+		Figi:          row[cols.Figi],
+		Price:         price,
+		Quantity_:     uint(math.Abs(quantity)),
+	}
+	if op.Currency == "" {
+		op.Currency = m.Currency
+	}
+
+	if ticker := row[cols.Ticker]; op.Figi == "" && ticker != "" {
+		ins, err := p.client.TryRequestByTicker(ticker)
+		if err != nil {
+			return op, fmt.Errorf("%s: %s", ticker, err)
+		}
+		op.Figi = ins.Figi
+		p.instruments[ins.Figi] = ins
+	}
+	if op.Figi != "" {
+		op.InstrumentType = string(p.insByFigi(op.Figi).Type)
+	}
+
+	if payment == 0 {
+		payment = price * quantity
+	}
+
+	// statements keep amounts positive, the sign comes from the type
+	payment = math.Abs(payment)
+	if op.IsTrading() && quantity != 0 {
+		// bond prices come in percents of the nominal, the amount is in the currency
+		op.Price = payment / math.Abs(quantity)
+		payment += math.Abs(accrued)
+	}
+	switch op.Kind() {
+	case schema.OpTrade, schema.OpTradeCard, schema.OpPayOut, schema.OpCommission,
+		schema.OpBrokerCommission, schema.OpPositionTax, schema.OpTax:
+		if typ != "Sell" {
+			payment = -payment
+		}
+	}
+	op.Payment = payment
+
+	if commission != 0 {
+		op.Commission = schema.NewCValue(-math.Abs(commission), op.Currency)
+	}
+
+	if op.IsTrading() {
+		op.Trades = []schema.Trade{
+			schema.Trade{
+				Date:     op.Date,
+				Price:    op.Price,
+				Quantity: op.Quantity_,
+			},
+		}
+	}
+
+	return op, nil
+}
+
+func (m ImportMapping) readStatement(p *Portfolio, fname string) (ops []schema.Operation) {
+	var rows [][]string
+	if strings.HasSuffix(strings.ToLower(fname), ".xlsx") {
+		rows = readXlsx(fname)
+	} else {
+		rows = readCsv(fname, m.Separator)
+	}
+
+	// there might be anything above the table header
+	var header []string
+	for i, row := range rows {
+		if header == nil {
+			for _, cell := range row {
+				if strings.TrimSpace(cell) == m.Columns.Date {
+					header = row
+					break
+				}
+			}
+			continue
+		}
+
+		sr := make(statementRow)
+		for j, cell := range row {
+			if j < len(header) {
+				sr[strings.TrimSpace(header[j])] = strings.TrimSpace(cell)
+			}
+		}
+		if sr[m.Columns.Date] == "" {
+			// the table is over
+			continue
+		}
+
+		op, err := m.makeOperation(p, sr)
+		if err != nil {
+			log.Warnf("%s:%d: %s", fname, i+1, err)
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	if header == nil {
+		log.Fatalf("%s: no %s column found", fname, m.Columns.Date)
+	}
+
+	return
+}
+
+// the same operation coming from the API and from a statement.
+// Trades are compared by the amount without the bond accrued interest
+func operationKey(op schema.Operation) string {
+	amount := math.Abs(op.Payment)
+	if op.IsTrading() {
+		amount = op.Price * math.Abs(float64(op.Quantity()))
+	}
+	return fmt.Sprintf("%s %s %d %.2f",
+		op.DateParsed.In(moscow).Format("2006/01/02"), op.Figi, op.Quantity(), amount)
+}
+
+// writeOperations replaces the file at once, so it is never left half written
+func writeOperations(fname string, ops []schema.Operation) {
+	data, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname)+".*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fname)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Import adds the operations of a statement to the operations file,
+// skipping the ones already known from the API or the file itself
+func (p *Portfolio) Import(fname, mappingFile string) {
+	if p.config.opsFile == "" {
+		log.Fatal("no operations file to import to")
+	}
+
+	m := readImportMapping(mappingFile)
+
+	known := make(map[string]bool)
+	for _, op := range p.getOperations(beginning) {
+		known[operationKey(op)] = true
+	}
+
+	ops := readOperations(p.config.opsFile)
+	added := 0
+	for _, op := range m.readStatement(p, fname) {
+		key := operationKey(op)
+		if known[key] {
+			log.Debugf("already known: %s", key)
+			continue
+		}
+		known[key] = true
+
+		ops = append(ops, op)
+		added++
+	}
+
+	if added > 0 {
+		writeOperations(p.config.opsFile, ops)
+	}
+
+	log.Infof("%d operations imported to %s", added, p.config.opsFile)
+}
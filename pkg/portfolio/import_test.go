@@ -0,0 +1,109 @@
+package portfolio
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestImportDedup(t *testing.T) {
+	m := brokerMapping
+	m.Columns.Ticker = ""
+	m.Columns.Figi = "FIGI"
+
+	p := &Portfolio{instruments: map[string]schema.Instrument{
+		"BOND": {Figi: "BOND", Ticker: "BOND", Type: schema.InsTypeBond, Currency: "RUB"},
+	}}
+
+	row := func(date, clock, quantity, price, amount, accrued string) statementRow {
+		return statementRow{
+			m.Columns.Date:     date,
+			m.Columns.Time:     clock,
+			m.Columns.Type:     "Покупка",
+			m.Columns.Figi:     "BOND",
+			m.Columns.Quantity: quantity,
+			m.Columns.Price:    price,
+			m.Columns.Payment:  amount,
+			m.Columns.Accrued:  accrued,
+			m.Columns.Currency: "RUB",
+		}
+	}
+
+	late := bondBuy()
+	late.DateParsed = time.Date(2021, 3, 1, 22, 30, 0, 0, time.UTC)
+	late.Date = late.DateParsed.Format(time.RFC3339)
+
+	tests := []struct {
+		name    string
+		api     schema.Operation
+		row     statementRow
+		same    bool
+		payment float64
+	}{
+		{
+			name:    "bond in percents, accrued apart",
+			api:     bondBuy(),
+			row:     row("01.03.2021", "10:00:00", "2", "100", "2 000,00", "40"),
+			same:    true,
+			payment: -2040,
+		},
+		{
+			name:    "moscow date",
+			api:     late,
+			row:     row("02.03.2021", "01:30:00", "2", "100", "2000", "40"),
+			same:    true,
+			payment: -2040,
+		},
+		{
+			name:    "another quantity",
+			api:     bondBuy(),
+			row:     row("01.03.2021", "10:00:00", "3", "100", "3000", "60"),
+			payment: -3060,
+		},
+		{
+			name:    "another day",
+			api:     bondBuy(),
+			row:     row("02.03.2021", "10:00:00", "2", "100", "2000", "40"),
+			payment: -2040,
+		},
+	}
+
+	for _, tt := range tests {
+		op, err := m.makeOperation(p, tt.row)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if math.Abs(op.Payment-tt.payment) > 1e-9 {
+			t.Errorf("%s: payment %.2f, expected %.2f", tt.name, op.Payment, tt.payment)
+		}
+		if same := operationKey(op) == operationKey(tt.api); same != tt.same {
+			t.Errorf("%s: keys %q and %q, expected the same: %v", tt.name,
+				operationKey(op), operationKey(tt.api), tt.same)
+		}
+	}
+}
+
+func TestWriteOperations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "ops.json")
+	writeOperations(fname, []schema.Operation{bondBuy()})
+	writeOperations(fname, append(readOperations(fname), bondBuy()))
+
+	if ops := readOperations(fname); len(ops) != 2 {
+		t.Errorf("%d operations, expected 2", len(ops))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left, expected 1", len(files))
+	}
+}
@@ -1,8 +1,6 @@
 package portfolio
 
 import (
-	"time"
-
 	log "github.com/sirupsen/logrus"
 
 	"../schema"
@@ -11,7 +9,7 @@ import (
 func (p *Portfolio) insByFigi(figi string) schema.Instrument {
 	ins, ok := p.instruments[figi]
 	if !ok {
-		ins = p.client.RequestByFigi(figi)
+		ins = p.classify(p.client.RequestByFigi(figi))
 		p.instruments[figi] = ins
 	}
 	log.Debug(ins)
@@ -25,7 +23,7 @@ func (p *Portfolio) insByTicker(ticker string) schema.Instrument {
 		}
 	}
 
-	ins := p.client.RequestByTicker(ticker)
+	ins := p.classify(p.client.RequestByTicker(ticker))
 	p.instruments[ins.Figi] = ins
 	return ins
 }
@@ -36,16 +34,3 @@ func (p *Portfolio) tryGetTicker(figi string) string {
 	}
 	return p.insByFigi(figi).Ticker
 }
-
-func (p *Portfolio) benchPricef(ins schema.Instrument) schema.PriceAt {
-	bench := ins.Benchmark()
-	if bench == "" {
-		return nil
-	}
-
-	bins := p.insByTicker(bench)
-
-	return func(t time.Time) float64 {
-		return p.cc.GetInCurrency(bins, ins.Currency, t)
-	}
-}
@@ -0,0 +1,268 @@
+package portfolio
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// status changes come within a few days after the operation
+const ledgerOverlap = 7 * 24 * time.Hour
+
+// ledgerEntry is a line of the ledger; later lines override earlier ones with the same ID
+type ledgerEntry struct {
+	schema.Operation
+
+	Account     string `json:"account"`
+	AccountType string `json:"accountType"`
+}
+
+func readLedger(fname string) (entries []ledgerEntry) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Fatalf("%s: %s", fname, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return
+}
+
+// ledgerKey identifies an operation within its account;
+// those without an ID are only the same if they are the same
+func ledgerKey(acc string, op schema.Operation) string {
+	if op.ID != "" {
+		return acc + "/" + op.ID
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return acc + "/" + string(data)
+}
+
+// latest versions of the operations, in the ledger order
+func ledgerOperations(entries []ledgerEntry) []ledgerEntry {
+	latest := make(map[string]int)
+	var ops []ledgerEntry
+
+	for _, e := range entries {
+		key := ledgerKey(e.Account, e.Operation)
+		if i, ok := latest[key]; ok {
+			ops[i] = e
+			continue
+		}
+		latest[key] = len(ops)
+		ops = append(ops, e)
+	}
+
+	return ops
+}
+
+// LedgerAccounts returns the account types by ids, for working offline
+func LedgerAccounts(fname string) map[string]string {
+	accs := make(map[string]string)
+	for _, e := range readLedger(fname) {
+		if e.AccountType != "" || accs[e.Account] == "" {
+			accs[e.Account] = e.AccountType
+		}
+	}
+	return accs
+}
+
+func ledgerInstrumentsFile(fname string) string {
+	return fname + ".instruments"
+}
+
+// WithLedger makes the operations come from the ledger,
+// which is synced with the API unless offline
+func (p *Portfolio) WithLedger(fname string, offline bool) *Portfolio {
+	p.config.ledgerFile = fname
+	p.config.offline = offline
+
+	if fname == "" || !offline {
+		return p
+	}
+
+	insFile := ledgerInstrumentsFile(fname)
+	if _, err := os.Stat(insFile); err != nil {
+		log.Warnf("no instruments saved along the ledger: %s", err)
+		return p
+	}
+	readJSON(insFile, &p.instruments)
+	for figi, ins := range p.instruments {
+		p.instruments[figi] = p.classify(ins)
+	}
+
+	return p
+}
+
+// ledgerAccountTypes are the known ones, the ledger ones,
+// and the API ones for the rest
+func (p *Portfolio) ledgerAccountTypes(accTypes, ledger map[string]string) map[string]string {
+	types := make(map[string]string)
+	missing := false
+
+	for _, acc := range p.accs {
+		types[acc] = accTypes[acc]
+		if types[acc] == "" {
+			types[acc] = ledger[acc]
+		}
+		if types[acc] == "" {
+			missing = true
+		}
+	}
+
+	if missing {
+		api := map[string]string{"": "Tinkoff"} // the default one
+		for _, acc := range p.client.RequestAccounts().Payload.Accounts {
+			api[acc.BrokerAccountID] = acc.BrokerAccountType
+		}
+		for _, acc := range p.accs {
+			if types[acc] == "" {
+				types[acc] = api[acc]
+			}
+		}
+	}
+
+	return types
+}
+
+// Sync appends the operations the ledger has not seen, or has seen in other state,
+// and returns their number
+func (p *Portfolio) Sync(accTypes map[string]string) int {
+	fname := p.config.ledgerFile
+	if fname == "" {
+		log.Fatal("no ledger to sync")
+	}
+
+	known := make(map[string][]byte)
+	last := make(map[string]time.Time)
+	ledgerTypes := make(map[string]string)
+	for _, e := range ledgerOperations(readLedger(fname)) {
+		data, _ := json.Marshal(e.Operation)
+		known[ledgerKey(e.Account, e.Operation)] = data
+		if e.AccountType != "" {
+			ledgerTypes[e.Account] = e.AccountType
+		}
+
+		t, err := time.Parse(time.RFC3339, e.Date)
+		if err == nil && t.After(last[e.Account]) {
+			last[e.Account] = t
+		}
+	}
+
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	types := p.ledgerAccountTypes(accTypes, ledgerTypes)
+
+	added := 0
+	for _, acc := range p.accs {
+		start := beginning
+		if t, ok := last[acc]; ok {
+			start = t.Add(-ledgerOverlap)
+		}
+
+		for _, op := range p.client.RequestOperations(start, acc).Payload.Operations {
+			// the account type is not the operation state
+			data, err := json.Marshal(op)
+			if err != nil {
+				log.Fatal(err)
+			}
+			key := ledgerKey(acc, op)
+			if string(known[key]) == string(data) {
+				continue
+			}
+			known[key] = data
+
+			line, err := json.Marshal(ledgerEntry{
+				Operation:   op,
+				Account:     acc,
+				AccountType: types[acc],
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err := f.Write(append(line, '\n')); err != nil {
+				log.Fatal(err)
+			}
+			added++
+
+			if op.Figi != "" {
+				p.insByFigi(op.Figi)
+			}
+		}
+	}
+
+	p.saveLedgerInstruments()
+
+	return added
+}
+
+func (p *Portfolio) saveLedgerInstruments() {
+	data, err := json.MarshalIndent(p.instruments, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = ioutil.WriteFile(ledgerInstrumentsFile(p.config.ledgerFile), data, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (p *Portfolio) readLedgerOperations(start time.Time) (ops []schema.Operation) {
+	if !p.config.offline {
+		p.Sync(nil)
+	}
+
+	accs := make(map[string]bool)
+	for _, acc := range p.accs {
+		accs[acc] = true
+	}
+
+	for _, e := range ledgerOperations(readLedger(p.config.ledgerFile)) {
+		if !accs[e.Account] {
+			continue
+		}
+
+		op := e.Operation
+		op.Account = e.Account
+
+		t, err := time.Parse(time.RFC3339, op.Date)
+		if err == nil && t.Before(start) {
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	return
+}
@@ -0,0 +1,55 @@
+package portfolio
+
+import (
+	"testing"
+
+	"../schema"
+)
+
+func TestLedgerOperations(t *testing.T) {
+	entry := func(acc, id, status string, payment float64) ledgerEntry {
+		return ledgerEntry{
+			Operation: schema.Operation{ID: id, Status: status, Payment: payment},
+			Account:   acc,
+		}
+	}
+
+	entries := []ledgerEntry{
+		entry("1", "a", "Progress", 10),
+		entry("2", "a", "Done", 20), // the same ID in another account
+		entry("1", "", "Done", 30),
+		entry("1", "a", "Done", 10), // a newer state
+		entry("1", "", "Done", 30),  // the same without ID
+		entry("1", "", "Done", 40),
+	}
+
+	ops := ledgerOperations(entries)
+
+	expected := []ledgerEntry{
+		entry("1", "a", "Done", 10),
+		entry("2", "a", "Done", 20),
+		entry("1", "", "Done", 30),
+		entry("1", "", "Done", 40),
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("got %d operations, expected %d: %v", len(ops), len(expected), ops)
+	}
+	for i, e := range expected {
+		if ops[i].Account != e.Account || ops[i].ID != e.ID ||
+			ops[i].Status != e.Status || ops[i].Payment != e.Payment {
+			t.Errorf("%d: got %v, expected %v", i, ops[i], e)
+		}
+	}
+}
+
+func TestLedgerAccountTypes(t *testing.T) {
+	p := &Portfolio{accs: []string{"1", "2"}}
+
+	types := p.ledgerAccountTypes(
+		map[string]string{"1": "TinkoffIis"},
+		map[string]string{"1": "Tinkoff", "2": "Tinkoff"})
+
+	if types["1"] != "TinkoffIis" || types["2"] != "Tinkoff" {
+		t.Errorf("got %v", types)
+	}
+}
@@ -0,0 +1,36 @@
+package portfolio
+
+import (
+	"../schema"
+)
+
+// LotPick tells which lots (by buy dates) a sell consumes
+// when the specific-lot cost basis is used
+type LotPick struct {
+	Ticker string
+	Sell   string
+	Buys   []string
+}
+
+func readLotPicks(fname string) (picks []LotPick) {
+	readJSON(fname, &picks)
+
+	return
+}
+
+func (p *Portfolio) WithCostBasis(method, lotsFile string) *Portfolio {
+	p.config.costBasis = schema.CostBasis(method)
+
+	if lotsFile == "" {
+		return p
+	}
+
+	for _, pick := range readLotPicks(lotsFile) {
+		if p.lotPicks[pick.Ticker] == nil {
+			p.lotPicks[pick.Ticker] = make(map[string][]string)
+		}
+		p.lotPicks[pick.Ticker][pick.Sell] = append(p.lotPicks[pick.Ticker][pick.Sell], pick.Buys...)
+	}
+
+	return p
+}
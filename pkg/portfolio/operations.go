@@ -3,37 +3,50 @@ package portfolio
 import (
 	"encoding/json"
 	"io/ioutil"
+	"math"
 	"os"
 	"sort"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"../aux"
+	"../candles"
 	"../schema"
 )
 
-func readOperations(fname string) (ops []schema.Operation) {
+// readJSON fails on a missing or broken file
+func readJSON(fname string, v interface{}) {
 	data, err := ioutil.ReadFile(fname)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return
-		}
 		log.Fatal(err)
 	}
 
-	err = json.Unmarshal(data, &ops)
+	err = json.Unmarshal(data, v)
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
+func readOperations(fname string) (ops []schema.Operation) {
+	if _, err := os.Stat(fname); os.IsNotExist(err) {
+		return
+	}
+
+	readJSON(fname, &ops)
 	return ops
 }
 
 func (p *Portfolio) getOperations(start time.Time) (ops []schema.Operation) {
-	if p.config.fictFile == "" {
+	if !p.isFictive() && p.config.ledgerFile != "" {
+		ops = append(ops, p.readLedgerOperations(start)...)
+	} else if !p.isFictive() {
 		for _, acc := range p.accs {
 			resp := p.client.RequestOperations(start, acc)
-			ops = append(ops, resp.Payload.Operations...)
+			for _, op := range resp.Payload.Operations {
+				op.Account = acc
+				ops = append(ops, op)
+			}
 		}
 	}
 
@@ -45,6 +58,11 @@ func (p *Portfolio) getOperations(start time.Time) (ops []schema.Operation) {
 		ops = append(ops, fetchFictives(p.client, p.cc, p.config.fictFile)...)
 	}
 
+	if p.config.strategyFile != "" {
+		cc := candles.NewCandleCache(p.client).WithPeriod(p.config.strategyStart, p.config.strategyPeriod)
+		ops = append(ops, fetchBacktest(p.client, cc, p.benchmarks, p.config.strategyFile)...)
+	}
+
 	for i := range ops {
 		var err error
 		ops[i].DateParsed, err = time.Parse(time.RFC3339, ops[i].Date)
@@ -52,17 +70,92 @@ func (p *Portfolio) getOperations(start time.Time) (ops []schema.Operation) {
 			log.Fatalf("Failed to parse time: %v", err)
 		}
 
-		/* hashtag #repayment_hacks
-		   Repayments come with delay, and if there were trading ops in the middle,
-		   their value calculation breaks.
-		   Uglyhack it here as I dont know what to do yet */
-		if ops[i].Figi == "BBG00LFKPBJ0" && ops[i].OperationType == "PartRepayment" {
-			ops[i].DateParsed = ops[i].DateParsed.Add(-24 * time.Hour)
+		if p.config.strict && !schema.IsKnownOperation(ops[i].OperationType) {
+			log.Fatalf("Unknown operation type %s: %v", ops[i].OperationType, ops[i])
 		}
 	}
 
+	ops = applyOverrides(ops, p.overrides)
+
 	sort.Slice(ops, func(i, j int) bool {
 		return ops[i].DateParsed.Before(ops[j].DateParsed)
 	})
+
+	markTransfers(ops)
 	return
 }
+
+const transferWindow = 3 * 24 * time.Hour
+
+func isTransferPair(out, in schema.Operation) bool {
+	if out.Account == in.Account || in.IsTransfer || in.Status != "Done" {
+		return false
+	}
+
+	if dt := in.DateParsed.Sub(out.DateParsed); dt > transferWindow || dt < -transferWindow {
+		return false
+	}
+
+	if out.OperationType == "PayOut" {
+		return in.OperationType == "PayIn" &&
+			in.Currency == out.Currency && math.Abs(in.Payment+out.Payment) < 0.01
+	}
+
+	return in.OperationType == "SecurityIn" &&
+		in.Figi == out.Figi && in.Quantity() == -out.Quantity()
+}
+
+// the incoming side of a transfer is looked for by currency or figi
+func transferKey(op schema.Operation) string {
+	switch op.OperationType {
+	case "PayIn", "PayOut":
+		return "money " + op.Currency
+	case "SecurityIn", "SecurityOut":
+		return "security " + op.Figi
+	}
+	return ""
+}
+
+// money or securities moved from one of our accounts to another one
+// are not payins when looking at both of them.
+// ops are sorted by date
+func markTransfers(ops []schema.Operation) {
+	ins := make(map[string][]int)
+	for i, op := range ops {
+		if aux.IsIn(op.OperationType, "PayIn", "SecurityIn") {
+			key := transferKey(op)
+			ins[key] = append(ins[key], i)
+		}
+	}
+
+	for i := range ops {
+		out := &ops[i]
+		if out.Status != "Done" || !aux.IsIn(out.OperationType, "PayOut", "SecurityOut") {
+			continue
+		}
+
+		idxs := ins[transferKey(*out)]
+		from := out.DateParsed.Add(-transferWindow)
+		k := sort.Search(len(idxs), func(k int) bool {
+			return !ops[idxs[k]].DateParsed.Before(from)
+		})
+
+		for ; k < len(idxs) && !out.IsTransfer; k++ {
+			j := idxs[k]
+			if ops[j].DateParsed.Sub(out.DateParsed) > transferWindow {
+				break
+			}
+			if isTransferPair(*out, ops[j]) {
+				out.IsTransfer = true
+				ops[j].IsTransfer = true
+				log.Debugf("transfer %s -> %s: %v", out.Account, ops[j].Account, ops[j])
+			}
+		}
+	}
+}
+
+// WithStrict makes unknown operation types fatal instead of being warned about and skipped
+func (p *Portfolio) WithStrict(strict bool) *Portfolio {
+	p.config.strict = strict
+	return p
+}
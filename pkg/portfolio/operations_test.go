@@ -0,0 +1,108 @@
+package portfolio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../candles"
+	"../schema"
+)
+
+func TestMarkTransfers(t *testing.T) {
+	op := func(d int, acc, typ, cur, figi string, payment float64, quantity uint) schema.Operation {
+		return schema.Operation{
+			Account:       acc,
+			OperationType: typ,
+			Status:        "Done",
+			Currency:      cur,
+			Figi:          figi,
+			DateParsed:    time.Date(2021, 1, d, 12, 0, 0, 0, time.UTC),
+			Payment:       payment,
+			Quantity_:     quantity,
+		}
+	}
+
+	ops := []schema.Operation{
+		op(1, "A", "PayOut", "RUB", "", -100, 0),
+		op(2, "B", "PayIn", "USD", "", 100, 0),
+		op(3, "B", "PayIn", "RUB", "", 100, 0),
+		op(3, "A", "SecurityOut", "", "X", 0, 5),
+		op(4, "B", "SecurityIn", "", "X", 0, 5),
+		op(5, "A", "PayOut", "RUB", "", -100, 0),
+		op(9, "B", "PayIn", "RUB", "", 100, 0),
+		op(10, "A", "PayIn", "RUB", "", 100, 0),
+	}
+	markTransfers(ops)
+
+	expected := []bool{true, false, true, true, true, false, false, false}
+	for i, op := range ops {
+		if op.IsTransfer != expected[i] {
+			t.Errorf("%d: %s %s on %s is transfer: %v, expected %v", i, op.Account, op.OperationType,
+				op.DateParsed.Format("2006/01/02"), op.IsTransfer, expected[i])
+		}
+	}
+}
+
+func TestConsolidatedTransfers(t *testing.T) {
+	op := func(d int, acc, typ string, payment float64) schema.Operation {
+		return schema.Operation{
+			Account:       acc,
+			OperationType: typ,
+			Status:        "Done",
+			Currency:      "RUB",
+			Date:          time.Date(2021, 1, d, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Payment:       payment,
+		}
+	}
+
+	a := []schema.Operation{
+		op(1, "A", "PayIn", 1000),
+		op(5, "A", "PayOut", -300), // to B
+	}
+	b := []schema.Operation{
+		op(6, "B", "PayIn", 300), // from A
+		op(9, "B", "PayOut", -200),
+	}
+
+	dir, err := ioutil.TempDir("", "transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	balance := func(ops []schema.Operation) *schema.Balance {
+		fname := filepath.Join(dir, "ops.json")
+		data, _ := json.Marshal(ops)
+		if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		p := NewPortfolio(nil, nil, fname, "")
+		p.cc = candles.NewCandleCache(nil)
+		return p.processOperations(func(*schema.Balance, time.Time) bool { return true })
+	}
+
+	tests := []struct {
+		name string
+		ops  []schema.Operation
+		exp  float64
+	}{
+		{"account A", a, 700},
+		{"account B", b, 100},
+		{"consolidated", append(append([]schema.Operation{}, a...), b...), 800},
+	}
+	for _, tt := range tests {
+		// the money just stays as cash
+		bal := balance(tt.ops)
+		if got := bal.Payins["all"].Value; got != tt.exp {
+			t.Errorf("%s: got payins %.0f, expected %.0f", tt.name, got, tt.exp)
+		}
+		if got := bal.Assets["RUB"].Value; got != tt.exp {
+			t.Errorf("%s: got cash %.0f, expected %.0f", tt.name, got, tt.exp)
+		}
+	}
+}
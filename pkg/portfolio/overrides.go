@@ -0,0 +1,144 @@
+package portfolio
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// Override corrects an operation found by ID,
+// or every operation of Figi and OperationType if there is no ID.
+// Empty fields are left as they are
+type Override struct {
+	ID                  string
+	Figi, OperationType string
+
+	Date  string // RFC3339
+	Shift string // the date by a duration, like "-24h"
+
+	Type     string
+	Price    *float64
+	Payment  *float64
+	Quantity *uint
+
+	Hide bool
+	Note string
+	Tags []string
+}
+
+func readOverrides(fname string) (ovs []Override) {
+	readJSON(fname, &ovs)
+
+	for _, ov := range ovs {
+		if ov.ID == "" && (ov.Figi == "" || ov.OperationType == "") {
+			log.Fatalf("%s: neither ID nor Figi and OperationType: %v", fname, ov)
+		}
+	}
+
+	return
+}
+
+func (ov Override) matches(op schema.Operation) bool {
+	if ov.ID != "" {
+		return ov.ID == op.ID
+	}
+	return ov.Figi == op.Figi && ov.OperationType == op.OperationType
+}
+
+func (ov Override) apply(op *schema.Operation) {
+	if ov.Date != "" {
+		t, err := time.Parse(time.RFC3339, ov.Date)
+		if err != nil {
+			log.Fatalf("override %s: %s", ov.ID, err)
+		}
+		op.Date, op.DateParsed = ov.Date, t
+	}
+	if ov.Shift != "" {
+		d, err := time.ParseDuration(ov.Shift)
+		if err != nil {
+			log.Fatalf("override %s: %s", ov.ID, err)
+		}
+		op.DateParsed = op.DateParsed.Add(d)
+		op.Date = op.DateParsed.Format(time.RFC3339)
+	}
+
+	if ov.Type != "" {
+		op.OperationType = ov.Type
+	}
+
+	// accrued interest per unit, for the payment to be recomputed
+	quantity := op.Quantity()
+	accrued := 0.0
+	if quantity != 0 {
+		accrued = (-op.Payment - op.Price*float64(quantity)) / float64(quantity)
+	}
+
+	if ov.Payment != nil {
+		op.Payment = *ov.Payment
+	}
+	if ov.Price != nil {
+		op.Price = *ov.Price
+		for i := range op.Trades {
+			op.Trades[i].Price = op.Price
+		}
+	}
+	if ov.Quantity != nil {
+		op.Quantity_ = *ov.Quantity
+		if len(op.Trades) > 0 {
+			op.Trades = []schema.Trade{
+				schema.Trade{
+					Date:     op.Date,
+					Price:    op.Price,
+					Quantity: op.Quantity_,
+				},
+			}
+		}
+	}
+
+	if ov.Payment == nil && (ov.Price != nil || ov.Quantity != nil) && op.IsTrading() {
+		// the payment is negative for Buy, commission excluded
+		quantity = op.Quantity()
+		op.Payment = -(op.Price + accrued) * float64(quantity)
+	}
+
+	if ov.Note != "" {
+		op.Note = ov.Note
+	}
+	op.Tags = append(op.Tags, ov.Tags...)
+}
+
+// applyOverrides patches the operations, and drops the hidden ones
+func applyOverrides(ops []schema.Operation, ovs []Override) []schema.Operation {
+	var res []schema.Operation
+
+	for _, op := range ops {
+		hidden := false
+		for _, ov := range ovs {
+			if !ov.matches(op) {
+				continue
+			}
+			log.Debugf("override %v: %v", ov, op)
+			if ov.Hide {
+				hidden = true
+				break
+			}
+			ov.apply(&op)
+		}
+
+		if !hidden {
+			res = append(res, op)
+		}
+	}
+
+	return res
+}
+
+// WithOverrides corrects the operations coming from anywhere with the ones in the file
+func (p *Portfolio) WithOverrides(fname string) *Portfolio {
+	if fname != "" {
+		p.overrides = readOverrides(fname)
+	}
+	return p
+}
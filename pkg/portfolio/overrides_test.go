@@ -0,0 +1,109 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func bondBuy() schema.Operation {
+	date := "2021-03-01T10:00:00+03:00"
+	t, _ := time.Parse(time.RFC3339, date)
+	return schema.Operation{
+		ID:            "1",
+		Figi:          "BOND",
+		OperationType: "Buy",
+		Status:        "Done",
+		Currency:      "RUB",
+		Date:          date,
+		DateParsed:    t,
+		Price:         1000,
+		Payment:       -2040, // 2 x (1000 + 20 accrued)
+		Quantity_:     2,
+		Trades:        []schema.Trade{{Date: date, Price: 1000, Quantity: 2}},
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	price := 990.0
+	payment := -1000.0
+	quantity := uint(3)
+
+	tests := []struct {
+		name     string
+		ov       Override
+		hidden   bool
+		price    float64
+		quantity int
+		payment  float64
+		date     string
+	}{
+		{
+			name:     "price keeps the accrued",
+			ov:       Override{ID: "1", Price: &price},
+			price:    990,
+			quantity: 2,
+			payment:  -2020,
+			date:     "2021-03-01T10:00:00+03:00",
+		},
+		{
+			name:     "quantity",
+			ov:       Override{ID: "1", Quantity: &quantity},
+			price:    1000,
+			quantity: 3,
+			payment:  -3060,
+			date:     "2021-03-01T10:00:00+03:00",
+		},
+		{
+			name:     "payment as is",
+			ov:       Override{ID: "1", Price: &price, Payment: &payment},
+			price:    990,
+			quantity: 2,
+			payment:  -1000,
+			date:     "2021-03-01T10:00:00+03:00",
+		},
+		{
+			name:     "shift",
+			ov:       Override{Figi: "BOND", OperationType: "Buy", Shift: "-24h"},
+			price:    1000,
+			quantity: 2,
+			payment:  -2040,
+			date:     "2021-02-28T10:00:00+03:00",
+		},
+		{
+			name:   "hide",
+			ov:     Override{ID: "1", Hide: true},
+			hidden: true,
+		},
+		{
+			name:     "another operation",
+			ov:       Override{ID: "2", Hide: true},
+			price:    1000,
+			quantity: 2,
+			payment:  -2040,
+			date:     "2021-03-01T10:00:00+03:00",
+		},
+	}
+
+	for _, tt := range tests {
+		ops := applyOverrides([]schema.Operation{bondBuy()}, []Override{tt.ov})
+
+		if tt.hidden {
+			if len(ops) != 0 {
+				t.Errorf("%s: not hidden", tt.name)
+			}
+			continue
+		}
+
+		op := ops[0]
+		if op.Price != tt.price || op.Quantity() != tt.quantity || math.Abs(op.Payment-tt.payment) > 1e-9 {
+			t.Errorf("%s: got %.2f x %d = %.2f, expected %.2f x %d = %.2f", tt.name,
+				op.Price, op.Quantity(), op.Payment, tt.price, tt.quantity, tt.payment)
+		}
+		if op.Date != tt.date || op.DateParsed.Format(time.RFC3339) != tt.date {
+			t.Errorf("%s: got date %s (%s), expected %s", tt.name, op.Date, op.DateParsed, tt.date)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"../aux"
 	"../candles"
 	"../client"
 	"../schema"
@@ -26,19 +27,47 @@ type Portfolio struct {
 	cc *candles.CandleCache
 
 	instruments map[string]schema.Instrument // key=figi
+	sections    *schema.Sections
+	benchmarks  *schema.Benchmarks // the defaults if nil
 	positions   map[string]*schema.PositionInfo
 
 	accrued map[string]float64
 
+	lotPicks map[string]map[string][]string // key=ticker
+
+	overrides []Override
+	tagRules  []TagRule
+
 	figisSorted []string
 
 	balance schema.SectionedBalance
+	cash    *schema.Balance // what is left after the deals
 	alphas  schema.CurMap
+	twr     aux.TwrCtx
+
+	trackTwr bool // value the portfolio at every cash flow
+
+	sectionFlows map[schema.Section]float64
+	fxFlows      map[schema.Section]*fxFlow
+
+	replay *benchReplay
 
 	config struct {
 		enableAccrued bool
 		opsFile       string
 		fictFile      string
+		costBasis     schema.CostBasis
+		strict        bool
+
+		benchmark      string
+		benchRebalance string
+
+		strategyFile   string
+		strategyStart  time.Time
+		strategyPeriod string
+
+		ledgerFile string
+		offline    bool
 	}
 }
 
@@ -48,13 +77,23 @@ func NewPortfolio(c *client.MyClient, accs []string, opsFile, fictFile string) *
 		accs:   accs,
 
 		instruments: make(map[string]schema.Instrument),
+		sections:    schema.DefaultSections(),
 		positions:   make(map[string]*schema.PositionInfo),
 		accrued:     make(map[string]float64),
+		lotPicks:    make(map[string]map[string][]string),
 
 		alphas: schema.NewCurMap(),
 	}
 	p.config.opsFile = opsFile
 	p.config.fictFile = fictFile
+	p.config.costBasis = schema.CostBasisAverage
+	p.config.benchRebalance = "month"
+	return p
+}
+
+// WithTwr values the portfolio at every cash flow, for the time-weighted return of show and story
+func (p *Portfolio) WithTwr(track bool) *Portfolio {
+	p.trackTwr = track
 	return p
 }
 
@@ -91,7 +130,10 @@ func (p *Portfolio) addPosition(op schema.Operation) *schema.PositionInfo {
 		Ins: p.insByFigi(op.Figi),
 
 		AccumulatedIncome: schema.NewCValue(0, op.Currency),
+
+		CostBasis: p.config.costBasis,
 	}
+	pinfo.LotPicks = p.lotPicks[pinfo.Ins.Ticker]
 
 	p.positions[op.Figi] = pinfo
 	return pinfo
@@ -106,6 +148,8 @@ func (p *Portfolio) processOperations(cb func(*schema.Balance, time.Time) bool)
 
 	bal := schema.NewBalance()
 
+	p.replay = p.newBenchReplay()
+
 	for _, op := range p.data.ops {
 		if op.Status != "Done" {
 			// cancelled declined etc
@@ -125,9 +169,20 @@ func (p *Portfolio) processOperations(cb func(*schema.Balance, time.Time) bool)
 			if isDeal {
 				bal.AddDeal(deal, pinfo.Ins.Figi)
 			}
+			p.addSectionFlow(pinfo, op, deal, isDeal)
+			p.addFxFlow(pinfo, op, deal, isDeal)
 		}
 
+		payins := bal.Payins["all"].Value
 		bal.AddOperation(op, p.cc.Xchgrate)
+		if flow := bal.Payins["all"].Value - payins; flow != 0 {
+			if p.replay != nil {
+				p.replay.addPayin(flow, op.DateParsed)
+			}
+			if p.trackTwr {
+				p.twr.AddFlow(p.valueAt(bal, op.DateParsed)-flow, flow, op.DateParsed)
+			}
+		}
 
 		log.Debugf(" [%s] %s at %s (%f) new balance: %f",
 			op.OperationType, p.tryGetTicker(op.Figi),
@@ -144,7 +199,7 @@ func (p *Portfolio) getFullPrice(pinfo *schema.PositionInfo, t time.Time) float6
 }
 
 func (p *Portfolio) openDealsSectionedBalance(time time.Time) schema.SectionedBalance {
-	sb := schema.NewSectionedBalance()
+	sb := schema.NewSectionedBalance(p.sections.Ordered)
 
 	for _, pinfo := range p.positions {
 		od, hasOd := pinfo.MakeOpenDeal(time,
@@ -158,7 +213,7 @@ func (p *Portfolio) openDealsSectionedBalance(time time.Time) schema.SectionedBa
 
 		log.Debugf("open deal %s %s %s", pinfo.Ins.Figi, pinfo.Ins.Ticker, od)
 
-		sb.AddDeal(od, pinfo.Ins.Figi, pinfo.Ins.Section)
+		sb.AddDeal(od, pinfo.Ins.Figi, p.sections.Weights(pinfo.Ins))
 	}
 
 	return sb
@@ -171,18 +226,32 @@ func (p *Portfolio) openDealsBalance(time time.Time) *schema.Balance {
 }
 
 func (p *Portfolio) Collect(at time.Time) {
-	if p.config.fictFile == "" {
+	if !p.isFictive() {
 		p.collectAccrued()
 	}
 
 	p.cc = candles.NewCandleCache(p.client)
+	p.fxFlows = make(map[schema.Section]*fxFlow)
 
+	isFirst := true
 	cash := p.processOperations(func(bal *schema.Balance, opTime time.Time) bool {
+		if isFirst && p.trackTwr {
+			// daily candles, as the flows come on any day
+			p.cc.WithPeriod(opTime, "day")
+		}
+		isFirst = false
 		return opTime.Before(at)
 	})
 
+	if p.trackTwr {
+		// the last sub-period ends at
+		p.twr.AddSnapshot(p.valueAt(cash, at), at)
+	}
+
+	p.cash = cash
 	p.balance = p.openDealsSectionedBalance(at)
 	p.balance.Total.Add(*cash)
+	p.balance.Total.SetTwr(p.twr)
 
 	for _, pinfo := range p.positions {
 		pinfo.Finalize(p.benchPricef(pinfo.Ins))
@@ -190,17 +259,23 @@ func (p *Portfolio) Collect(at time.Time) {
 	}
 
 	p.calcAllAssets(p.balance, p.alphas, at)
+	p.cash.CalcAllAssets(p.cc.Get(schema.FigiUSD, at), 0)
 }
 
-// =============================================================================
+// freeCash is the money of all currencies not in the positions, RUB
+func (p *Portfolio) freeCash() float64 {
+	if p.cash == nil {
+		return 0
+	}
+	return p.cash.Assets["all"].Value
+}
 
-func (p *Portfolio) ListDeals(start, end time.Time) {
-	empty := true
+// =============================================================================
 
+func (p *Portfolio) ListDeals(start, end time.Time, filter DealsFilter) {
 	p.data.ops = p.getOperations(start)
 
-	deals := schema.NewBalance()
-	comms := schema.NewBalance()
+	var rows []dealRow
 	for _, op := range p.data.ops {
 		if op.DateParsed.After(end) {
 			break
@@ -215,13 +290,60 @@ func (p *Portfolio) ListDeals(start, end time.Time) {
 		if op.Figi != "" {
 			op.Ticker = p.insByFigi(op.Figi).Ticker
 		}
-		fmt.Printf("%s\n", op.StringPretty())
+		tags := p.dealTags(&op)
+
+		if filter.matches(op) {
+			rows = append(rows, dealRow{op, tags})
+		}
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	filter.sort(rows)
+	groups := filter.group(rows)
+
+	switch filter.Format {
+	case "", "human":
+	case "json":
+		printDealsJson(groups)
+		return
+	default:
+		printDealsTable(groups)
+		return
+	}
+
+	for _, g := range groups {
+		if filter.GroupBy != "" {
+			fmt.Printf("== %s ==\n", g.Key)
+		}
+		for _, op := range g.Operations {
+			fmt.Printf("%s\n", op.StringPretty())
+		}
+		if filter.GroupBy != "" {
+			fmt.Printf(" - Subtotal: %s\n", g.totalsString())
+		}
+	}
+
+	empty := true
+	deals := schema.NewBalance()
+	comms := schema.NewBalance()
+	tagDeals := make(map[string]*schema.Balance)
+	for _, row := range rows {
+		op := row.op
 
 		// exploit those balance maps for totals
 		if op.IsTrading() {
 			deals.Assets[op.Currency].Value += math.Abs(op.Payment)
+			for _, tag := range row.tags {
+				if tagDeals[tag] == nil {
+					tagDeals[tag] = schema.NewBalance()
+				}
+				tagDeals[tag].Assets[op.Currency].Value += math.Abs(op.Payment)
+			}
 			empty = false
-		} else if op.OperationType == "ServiceCommission" || op.OperationType == "BrokerCommission" {
+		} else if op.IsCommission() {
 			comms.Assets[op.Currency].Value += math.Abs(op.Payment)
 			empty = false
 		}
@@ -237,6 +359,9 @@ func (p *Portfolio) ListDeals(start, end time.Time) {
 			fmt.Printf("\t %s\n", deals.Assets[c])
 		}
 	}
+	if len(tagDeals) > 0 {
+		printTagTotals(tagDeals)
+	}
 	fmt.Printf("   commissions:\n")
 	for _, c := range schema.CurrenciesOrdered {
 		if comms.Assets[c].Value != 0 {
@@ -245,7 +370,9 @@ func (p *Portfolio) ListDeals(start, end time.Time) {
 	}
 
 	usdrate := p.client.RequestCurrentPrice(schema.FigiUSD)
-	fmt.Printf("   percentage: %.2f%%\n", comms.CalcAllAssets(usdrate, 0)/deals.CalcAllAssets(usdrate, 0)*100)
+	if total := deals.CalcAllAssets(usdrate, 0); total != 0 {
+		fmt.Printf("   percentage: %.2f%%\n", comms.CalcAllAssets(usdrate, 0)/total*100)
+	}
 }
 
 // =============================================================================
@@ -260,16 +387,30 @@ func (p *Portfolio) calcAllAssets(sb schema.SectionedBalance, alphas schema.CurM
 	}
 }
 
-func (p *Portfolio) summarize( /* const */ bal schema.Balance, t time.Time, format string) {
+func (p *Portfolio) summarize( /* const */ bal schema.Balance, t time.Time) schema.SectionedBalance {
 	obal := p.openDealsSectionedBalance(t)
 	obal.Total.Add(bal)
 
 	p.calcAllAssets(obal, nil, t)
 
-	obal.Print(t, t.Format("2006/01/02"), format)
+	p.twr.AddSnapshot(obal.Total.Assets["all"].Value, t)
+	obal.Total.SetTwr(p.twr)
+
+	return obal
 }
 
-func (p *Portfolio) ListBalances(start time.Time, period, format string) {
+// valueAt is the value of the positions and the cash, RUB
+func (p *Portfolio) valueAt( /* const */ bal *schema.Balance, t time.Time) float64 {
+	obal := p.openDealsSectionedBalance(t)
+	obal.Total.Add(*bal)
+
+	p.calcAllAssets(obal, nil, t)
+
+	return obal.Total.Assets["all"].Value
+}
+
+// walkBalances calls cb with a snapshot of the whole portfolio at every candle time
+func (p *Portfolio) walkBalances(start time.Time, period string, cb func(sb schema.SectionedBalance, t time.Time)) {
 	p.cc = candles.NewCandleCache(p.client).WithPeriod(start, period)
 
 	candleTimes := p.cc.ListTimes()
@@ -282,8 +423,6 @@ func (p *Portfolio) ListBalances(start time.Time, period, format string) {
 		return
 	}
 
-	schema.PrintBalanceHead(format)
-
 	bal := p.processOperations(func(bal *schema.Balance, opTime time.Time) bool {
 
 		// process all candles before opTime
@@ -293,7 +432,7 @@ func (p *Portfolio) ListBalances(start time.Time, period, format string) {
 			if opTime.Before(nextTime) {
 				break
 			}
-			p.summarize(*bal, nextTime, format)
+			cb(p.summarize(*bal, nextTime), nextTime)
 		}
 
 		return true
@@ -305,6 +444,55 @@ func (p *Portfolio) ListBalances(start time.Time, period, format string) {
 
 	for ; cidx < num; cidx += 1 {
 		nextTime := candleTimes[cidx]
-		p.summarize(*bal, nextTime, format)
+		cb(p.summarize(*bal, nextTime), nextTime)
+	}
+}
+
+// storyRow waits for the fx columns, the sections holding USD are known at the end
+type storyRow struct {
+	s   string
+	fas []fxAttribution
+}
+
+func (p *Portfolio) ListBalances(start time.Time, period, format string) {
+	var extra []string
+	if p.config.benchmark != "" {
+		extra = append(extra, "bench.assets", "bench.xirr", "bench.delta", "bench.twr")
+	}
+	if len(p.tagRules) > 0 {
+		extra = append(extra, tagHead(p.tagsOrdered())...)
+	}
+
+	var rows []storyRow
+	p.fxFlows = make(map[schema.Section]*fxFlow)
+	p.walkBalances(start, period, func(sb schema.SectionedBalance, t time.Time) {
+		s := sb.String(t, t.Format("2006/01/02"), format)
+		if p.replay != nil {
+			if format != schema.TableStyle {
+				s += " | bench "
+			}
+			s += p.replay.String(t, format)
+		}
+		if len(p.tagRules) > 0 {
+			if format != schema.TableStyle {
+				s += " | tags "
+			}
+			s += p.tagColumns(t, format)
+		}
+		rows = append(rows, storyRow{s, p.fxAttributions(sb, t)})
+	})
+
+	sections := p.fxSections()
+	schema.PrintBalanceHead(format, p.sections.Ordered, append(extra, fxHead(sections)...)...)
+
+	for _, row := range rows {
+		s := row.s
+		if fx := fxColumns(row.fas, sections, format); fx != "" {
+			if format != schema.TableStyle {
+				s += " | fx"
+			}
+			s += fx
+		}
+		fmt.Println(s)
 	}
 }
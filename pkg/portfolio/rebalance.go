@@ -0,0 +1,235 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// Target is either a section or a ticker one.
+// Section targets are traded with the Ticker, or with the section benchmark
+type Target struct {
+	Section schema.Section
+	Ticker  string
+	Weight  float64 // % of the whole portfolio
+}
+
+func readTargets(fname string) (targets []Target) {
+	readJSON(fname, &targets)
+
+	return
+}
+
+type rebalanceItem struct {
+	target Target
+	ins    schema.Instrument
+
+	price   float64 // in instrument currency
+	rate    float64 // instrument currency -> RUB
+	current float64 // RUB
+	desired float64 // RUB
+	held    int     // units of ins, no more can be sold
+
+	units int // to buy, negative to sell
+}
+
+func (it rebalanceItem) lotValue() float64 {
+	return it.price * float64(it.ins.Lot) * it.rate
+}
+
+// RUB, fees excluded
+func (it rebalanceItem) amount() float64 {
+	return it.price * float64(it.units) * it.rate
+}
+
+func (it rebalanceItem) name() string {
+	if it.target.Section != "" {
+		return string(it.target.Section)
+	}
+	return it.ins.Ticker
+}
+
+func (p *Portfolio) positionValue(ticker string) float64 {
+	for _, pinfo := range p.positions {
+		if pinfo.Ins.Ticker == ticker && !pinfo.IsClosed() {
+			return -pinfo.OpenDeal.Value() * p.cc.Xchgrate(pinfo.Ins.Currency, "RUB", time.Now())
+		}
+	}
+	return 0
+}
+
+func (p *Portfolio) positionQuantity(ticker string) int {
+	for _, pinfo := range p.positions {
+		if pinfo.Ins.Ticker == ticker && !pinfo.IsClosed() {
+			return pinfo.OpenQuantity
+		}
+	}
+	return 0
+}
+
+func (p *Portfolio) makeRebalanceItems(targets []Target, total float64) (items []*rebalanceItem) {
+	now := time.Now()
+
+	for _, t := range targets {
+		it := &rebalanceItem{
+			target:  t,
+			desired: total * t.Weight / 100,
+		}
+
+		ticker := t.Ticker
+		if t.Section != "" && ticker == "" {
+			ticker = p.benchmark(schema.Instrument{Section: t.Section})
+			if ticker == "" || strings.Contains(ticker, ",") {
+				log.Fatalf("no ticker to buy %s with", t.Section)
+			}
+		}
+		it.ins = p.insByTicker(ticker)
+		it.price = p.client.RequestCurrentPrice(it.ins.Figi)
+		it.rate = p.cc.Xchgrate(it.ins.Currency, "RUB", now)
+		it.held = p.positionQuantity(ticker)
+
+		if t.Section != "" {
+			if bal := p.balance.Sections[t.Section]; bal != nil {
+				it.current = bal.Assets["all"].Value
+			}
+		} else {
+			it.current = p.positionValue(ticker)
+		}
+
+		items = append(items, it)
+	}
+
+	// ticker targets are not counted in their sections
+	for _, it := range items {
+		if it.target.Section != "" {
+			continue
+		}
+		for section, w := range p.sections.Weights(it.ins) {
+			for _, sit := range items {
+				if sit.target.Section == section {
+					sit.current -= it.current * w
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// spreads the new cash among the underweight targets only
+func buyOnly(items []*rebalanceItem, cash float64) {
+	deficit := 0.0
+	for _, it := range items {
+		deficit += math.Max(0, it.desired-it.current)
+	}
+
+	for _, it := range items {
+		amount := math.Max(0, it.desired-it.current)
+		if deficit > cash {
+			amount *= cash / deficit
+		}
+		it.desired = it.current + amount
+	}
+}
+
+// planDeals sets the lot-rounded units to trade, spending no more than left
+// (RUB, commission included), and returns what remains
+func planDeals(items []*rebalanceItem, cash, left, fee float64, isBuyOnly bool) float64 {
+	if isBuyOnly {
+		buyOnly(items, cash)
+	}
+
+	for _, it := range items {
+		diff := it.desired - it.current
+		if diff > 0 {
+			// commission is paid on top
+			diff /= 1 + fee
+		}
+		it.units = lotRound(diff/it.rate, it.price, it.ins.Lot)
+		// an overweight section is sold only as far as its ticker is held
+		if it.units < -it.held {
+			it.units = -it.held
+		}
+		left -= it.amount() + math.Abs(it.amount())*fee
+	}
+
+	// rounding might have overspent
+	for left < 0 {
+		var worst *rebalanceItem
+		for _, it := range items {
+			if it.units > 0 && (worst == nil ||
+				it.current+it.amount()-it.desired > worst.current+worst.amount()-worst.desired) {
+				worst = it
+			}
+		}
+		if worst == nil {
+			break
+		}
+		worst.units -= worst.ins.Lot
+		left += worst.lotValue() * (1 + fee)
+	}
+
+	// spend the rest on the most underweight ones
+	for {
+		var best *rebalanceItem
+		for _, it := range items {
+			if it.lotValue()*(1+fee) > left {
+				continue
+			}
+			if best == nil || it.current+it.amount()-it.desired < best.current+best.amount()-best.desired {
+				best = it
+			}
+		}
+		if best == nil || best.current+best.amount()-best.desired+best.lotValue()/2 > 0 {
+			break
+		}
+		best.units += best.ins.Lot
+		left -= best.lotValue() * (1 + fee)
+	}
+
+	return left
+}
+
+// Rebalance proposes the deals that bring the portfolio closest to the targets.
+// fee is the broker commission (0.003 for 0.3%)
+func (p *Portfolio) Rebalance(targetsFile string, cash, fee float64, isBuyOnly bool) {
+	targets := readTargets(targetsFile)
+
+	p.Collect(time.Now())
+
+	total := p.assets() + cash
+	items := p.makeRebalanceItems(targets, total)
+
+	// money left after the deals, RUB
+	left := cash
+	if !isBuyOnly {
+		left = p.freeCash() + cash
+	}
+
+	left = planDeals(items, cash, left, fee, isBuyOnly)
+
+	fmt.Println("== Rebalance ==")
+
+	fees := 0.0
+	for _, it := range items {
+		action := "keep"
+		if it.units > 0 {
+			action = "buy "
+		} else if it.units < 0 {
+			action = "sell"
+		}
+		fees += math.Abs(it.amount()) * fee
+
+		fmt.Printf("%-10s %-5s %5.1f%% -> %5.1f%% (target %5.1f%%): %s %4d x %.2f %s = %.0f RUB\n",
+			it.name(), it.ins.Ticker,
+			100*it.current/total, 100*(it.current+it.amount())/total, it.target.Weight,
+			action, it.units, it.price, it.ins.Currency, it.amount())
+	}
+
+	fmt.Printf("cash left: %.0f RUB, fees: %.0f RUB\n", left, fees)
+}
@@ -0,0 +1,95 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+
+	"../schema"
+)
+
+func rebalanceItems() []*rebalanceItem {
+	ins := func(ticker string) schema.Instrument {
+		return schema.Instrument{Ticker: ticker, Currency: "RUB", Lot: 1}
+	}
+	return []*rebalanceItem{
+		{ins: ins("OVER"), price: 100, rate: 1, current: 60000, desired: 50000, held: 600},
+		{ins: ins("UNDER"), price: 100, rate: 1, current: 40000, desired: 50000, held: 400},
+	}
+}
+
+func TestRebalanceNoSpareCash(t *testing.T) {
+	cash := schema.NewBalance()
+	cash.CalcAllAssets(75, 0)
+
+	p := &Portfolio{cash: cash}
+	p.balance = schema.NewSectionedBalance(nil)
+	// the open positions are in the total, but are not to be spent
+	p.balance.Total.Assets["RUB"].Value = 100000
+	p.balance.Total.CalcAllAssets(75, 0)
+
+	if left := p.freeCash(); left != 0 {
+		t.Fatalf("free cash %f, expected 0", left)
+	}
+
+	items := rebalanceItems()
+	left := planDeals(items, 0, p.freeCash(), 0.003, false)
+
+	if left < 0 {
+		t.Errorf("overspent: %f left", left)
+	}
+	if items[0].units >= 0 || items[1].units <= 0 {
+		t.Errorf("expected to sell OVER and buy UNDER, got %d and %d", items[0].units, items[1].units)
+	}
+	if items[1].units > -items[0].units {
+		t.Errorf("bought %d, more than sold %d", items[1].units, -items[0].units)
+	}
+}
+
+func TestRebalanceBuyOnlyNoCash(t *testing.T) {
+	items := rebalanceItems()
+	left := planDeals(items, 0, 0, 0.003, true)
+
+	if left != 0 {
+		t.Errorf("%f left, expected 0", left)
+	}
+	for _, it := range items {
+		if it.units != 0 {
+			t.Errorf("%s: %d units traded without cash", it.ins.Ticker, it.units)
+		}
+	}
+}
+
+func TestFreeCashCurrencies(t *testing.T) {
+	cash := schema.NewBalance()
+	cash.Assets["RUB"].Value = 1000
+	cash.Assets["USD"].Value = 10
+	cash.CalcAllAssets(75, 0)
+
+	p := &Portfolio{cash: cash}
+	if left := p.freeCash(); left != 1750 {
+		t.Errorf("free cash %f, expected 1750", left)
+	}
+}
+
+func TestRebalanceSectionWithoutBenchmark(t *testing.T) {
+	items := rebalanceItems()
+	// the section is overweight, but holds some other funds, not the one to trade it with
+	items[0].target = Target{Section: schema.StockRub}
+	items[0].held = 0
+
+	left := planDeals(items, 0, 0, 0.003, false)
+
+	if items[0].units != 0 {
+		t.Errorf("sold %d units not held", -items[0].units)
+	}
+	if items[1].units != 0 || math.Abs(left) > 1e-6 {
+		t.Errorf("bought %d units with no cash, %f left", items[1].units, left)
+	}
+
+	items = rebalanceItems()
+	items[0].held = 30
+	planDeals(items, 0, 0, 0.003, false)
+	if items[0].units != -30 {
+		t.Errorf("sold %d units, expected all the 30 held", -items[0].units)
+	}
+}
@@ -37,7 +37,7 @@ func (p *Portfolio) preprocessOperations() {
 			continue
 		}
 
-		if op.IsTrading() {
+		if op.IsDeal() {
 			amounts[op.Figi] += op.Quantity()
 
 		} else if op.OperationType == "PartRepayment" {
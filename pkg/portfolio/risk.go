@@ -0,0 +1,195 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"../aux"
+	"../schema"
+)
+
+// riskSeries is a list of period returns with the cash flows excluded
+type riskSeries struct {
+	twr     aux.TwrCtx
+	returns []float64
+	starts  []time.Time
+	ends    []time.Time
+}
+
+func (rs *riskSeries) addSnapshot(value, flows float64, t time.Time) {
+	wasValid := rs.twr.Valid()
+	last := time.Time{}
+	if len(rs.ends) > 0 {
+		last = rs.ends[len(rs.ends)-1]
+	}
+
+	rs.twr.AddPeriod(value, flows, t)
+	if !wasValid {
+		if rs.twr.Valid() {
+			rs.ends = append(rs.ends, t)
+		}
+		return
+	}
+
+	rs.returns = append(rs.returns, rs.twr.Last()-1)
+	rs.starts = append(rs.starts, last)
+	rs.ends = append(rs.ends, t)
+}
+
+type riskStats struct {
+	name, benchmark string
+
+	annual, volatility, drawdown float64
+	drawdownDays                 int
+	sharpe, sortino, beta        float64
+}
+
+func (rs riskSeries) stats(riskFree float64, benchPricef schema.PriceAt) (st riskStats) {
+	if len(rs.returns) < 2 {
+		return
+	}
+
+	first, last := rs.ends[0], rs.ends[len(rs.ends)-1]
+	perYear := float64(len(rs.returns)) / (last.Sub(first).Hours() / 24 / 365)
+	rfPeriod := math.Pow(1+riskFree, 1/perYear) - 1
+
+	st.annual = rs.twr.Annual(last) - 1
+	st.volatility = aux.StdDev(rs.returns) * math.Sqrt(perYear)
+	if st.volatility != 0 {
+		st.sharpe = (st.annual - riskFree) / st.volatility
+	}
+	if dd := aux.DownsideDev(rs.returns, rfPeriod) * math.Sqrt(perYear); dd != 0 {
+		st.sortino = (st.annual - riskFree) / dd
+	}
+
+	index := []float64{1}
+	for _, r := range rs.returns {
+		index = append(index, index[len(index)-1]*(1+r))
+	}
+	var from, to int
+	st.drawdown, from, to = aux.MaxDrawdown(index)
+	st.drawdownDays = int(rs.ends[to].Sub(rs.ends[from]).Hours() / 24)
+
+	if benchPricef != nil {
+		bench := make([]float64, len(rs.returns))
+		for i := range rs.returns {
+			bench[i] = benchPricef(rs.ends[i+1])/benchPricef(rs.starts[i]) - 1
+		}
+		st.beta = aux.Beta(rs.returns, bench)
+	}
+
+	return
+}
+
+func (st riskStats) print(format string) {
+	if format == schema.TableStyle {
+		fmt.Printf("%s, %s, %.1f, %.1f, %.1f, %d, %.2f, %.2f, %.2f\n",
+			st.name, st.benchmark,
+			st.annual*100, st.volatility*100, st.drawdown*100, st.drawdownDays,
+			st.sharpe, st.sortino, st.beta)
+		return
+	}
+
+	fmt.Printf("%-10s (%-4s): annual %5.1f%%, volatility %5.1f%%, max drawdown %5.1f%% (%d days), "+
+		"sharpe %5.2f, sortino %5.2f, beta %5.2f\n",
+		st.name, st.benchmark,
+		st.annual*100, st.volatility*100, st.drawdown*100, st.drawdownDays,
+		st.sharpe, st.sortino, st.beta)
+}
+
+// =============================================================================
+
+// money put into the sections, RUB; only tracked for the risk stats
+func (p *Portfolio) addSectionFlow(pinfo *schema.PositionInfo, op schema.Operation, deal schema.Deal, isDeal bool) {
+	if p.sectionFlows == nil || pinfo.Ins.Figi == schema.FigiUSD {
+		return
+	}
+
+	flow := 0.0
+	if isDeal {
+		flow = deal.Expense()
+	} else if op.IsPayment() {
+		// the income leaves the section for cash
+		flow = -op.Payment
+	} else {
+		return
+	}
+
+	flow *= p.cc.Xchgrate(op.Currency, "RUB", op.DateParsed)
+	for section, w := range p.sections.Weights(pinfo.Ins) {
+		p.sectionFlows[section] += flow * w
+	}
+}
+
+// Risk prints volatility, drawdown, Sharpe & Sortino ratios and beta
+// of the whole portfolio and of every section.
+// riskFree is the annual risk-free rate (0.05 for 5%)
+func (p *Portfolio) Risk(start time.Time, period, format, benchmark string, riskFree float64) {
+	total := &riskSeries{}
+	sections := make(map[schema.Section]*riskSeries)
+	var last schema.SectionedBalance
+
+	p.sectionFlows = make(map[schema.Section]float64)
+
+	p.walkBalances(start, period, func(sb schema.SectionedBalance, t time.Time) {
+		total.addSnapshot(sb.Total.Assets["all"].Value, sb.Total.Payins["all"].Value, t)
+
+		for section := range sb.Sections {
+			if sections[section] == nil {
+				sections[section] = &riskSeries{}
+			}
+		}
+
+		for section, rs := range sections {
+			value := 0.0
+			if bal := sb.Sections[section]; bal != nil {
+				value = bal.Assets["all"].Value
+			}
+			rs.addSnapshot(value, p.sectionFlows[section], t)
+		}
+
+		last = sb
+	})
+
+	var names []schema.Section
+	for section := range sections {
+		names = append(names, section)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	if benchmark == "" {
+		benchmark = p.config.benchmark
+	}
+	if benchmark == "" {
+		// the benchmark of the biggest section
+		biggest := 0.0
+		for _, section := range names {
+			if bal := last.Sections[section]; bal != nil && bal.Assets["all"].Value > biggest {
+				biggest = bal.Assets["all"].Value
+				benchmark = p.benchmark(schema.Instrument{Section: section})
+			}
+		}
+	}
+
+	if format == schema.TableStyle {
+		fmt.Println("section, benchmark, annual, volatility, drawdown, drawdown.days, sharpe, sortino, beta")
+	} else {
+		fmt.Println("== Risk ==")
+	}
+
+	st := total.stats(riskFree, p.benchIndex(benchmark, "RUB"))
+	st.name, st.benchmark = "total", benchmark
+	st.print(format)
+
+	for _, section := range names {
+		bench := p.benchmark(schema.Instrument{Section: section})
+
+		st := sections[section].stats(riskFree, p.benchIndex(bench, "RUB"))
+		st.name, st.benchmark = string(section), bench
+		st.print(format)
+	}
+}
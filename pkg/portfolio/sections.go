@@ -0,0 +1,47 @@
+package portfolio
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// SectionsConfig defines custom asset classes.
+// Assign and Split keys are tickers or figis, Split values are weights
+type SectionsConfig struct {
+	Sections []schema.Section
+	Assign   map[string]schema.Section
+	Split    map[string]map[schema.Section]float64
+}
+
+func readSections(fname string) *schema.Sections {
+	var cfg SectionsConfig
+	readJSON(fname, &cfg)
+
+	ss := schema.DefaultSections()
+	if len(cfg.Sections) > 0 {
+		ss.Ordered = cfg.Sections
+	}
+	ss.Assign = cfg.Assign
+	ss.Split = cfg.Split
+
+	if err := ss.Validate(); err != nil {
+		log.Fatalf("%s: %s", fname, err)
+	}
+
+	return ss
+}
+
+// WithSections replaces the default sections with the ones in the file
+func (p *Portfolio) WithSections(fname string) *Portfolio {
+	if fname != "" {
+		p.sections = readSections(fname)
+	}
+	return p
+}
+
+// classify assigns the instrument its configured section
+func (p *Portfolio) classify(ins schema.Instrument) schema.Instrument {
+	ins.Section = p.sections.Of(ins)
+	return ins
+}
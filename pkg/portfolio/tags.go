@@ -0,0 +1,241 @@
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../aux"
+	"../schema"
+)
+
+const untagged = "-"
+
+// TagRule puts an instrument (found by Ticker or Figi) under a strategy tag.
+// With From and To (2006/01/02), only the portions opened
+// or the deals made within those dates get the tag
+type TagRule struct {
+	Tag          string
+	Ticker, Figi string
+	From, To     string
+
+	from, to time.Time
+}
+
+func readTagRules(fname string) (rules []TagRule) {
+	readJSON(fname, &rules)
+
+	var err error
+	for i := range rules {
+		r := &rules[i]
+		if r.From != "" {
+			if r.from, err = time.Parse("2006/01/02", r.From); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if r.To != "" {
+			if r.to, err = time.Parse("2006/01/02", r.To); err != nil {
+				log.Fatal(err)
+			}
+			// the whole day
+			r.to = r.to.AddDate(0, 0, 1)
+		}
+	}
+
+	return
+}
+
+// WithTags groups show, story and deals by strategy tags
+func (p *Portfolio) WithTags(fname string) *Portfolio {
+	if fname != "" {
+		p.tagRules = readTagRules(fname)
+	}
+	return p
+}
+
+// tagOf returns the first matching tag
+func (p *Portfolio) tagOf(ins schema.Instrument, t time.Time) string {
+	for _, r := range p.tagRules {
+		if r.Ticker != ins.Ticker && r.Figi != ins.Figi {
+			continue
+		}
+		if !r.from.IsZero() && t.Before(r.from) || !r.to.IsZero() && !t.Before(r.to) {
+			continue
+		}
+		return r.Tag
+	}
+	return untagged
+}
+
+func (p *Portfolio) tagsOrdered() (tags []string) {
+	seen := make(map[string]bool)
+	for _, r := range p.tagRules {
+		if !seen[r.Tag] {
+			seen[r.Tag] = true
+			tags = append(tags, r.Tag)
+		}
+	}
+	return append(tags, untagged)
+}
+
+type tagTotal struct {
+	open, value, expense, alpha float64 // RUB
+	xirr                        aux.XirrCtx
+	last                        time.Time
+}
+
+func (tt tagTotal) yield() float64 {
+	if tt.expense == 0 {
+		return 0
+	}
+	return aux.Ratio2Perc(tt.value / tt.expense)
+}
+
+func (tt tagTotal) annual() (float64, error) {
+	rate, err := tt.xirr.Ratio(0, tt.last)
+	return rate * 100, err
+}
+
+// tagTotals totals the portions by tags, in RUB.
+// The open portions are closed at t on the copies of the positions
+func (p *Portfolio) tagTotals(t time.Time) map[string]*tagTotal {
+	totals := make(map[string]*tagTotal)
+
+	for _, pinfo := range p.positions {
+		if pinfo.Ins.Figi == schema.FigiUSD || len(pinfo.Portions) == 0 {
+			continue
+		}
+
+		rate := func(t time.Time) float64 {
+			return p.cc.Xchgrate(pinfo.Ins.Currency, "RUB", t)
+		}
+
+		pinfo = pinfo.Copy()
+		od, hasOd := pinfo.MakeOpenDeal(t, func() float64 {
+			return p.getFullPrice(pinfo, t)
+		})
+		pinfo.Finalize(p.benchPricef(pinfo.Ins))
+
+		for i, po := range pinfo.Portions {
+			if len(po.Buys) == 0 {
+				continue
+			}
+
+			tag := p.tagOf(pinfo.Ins, po.Buys[0].Date)
+			if totals[tag] == nil {
+				totals[tag] = &tagTotal{}
+			}
+			tt := totals[tag]
+
+			var xirr aux.XirrCtx
+			value, expense, result := pinfo.PortionFlows(po, &xirr)
+
+			closeRate := rate(po.Close.Date)
+			if hasOd && i == len(pinfo.Portions)-1 {
+				tt.open += -od.Value() * closeRate
+			}
+			tt.value += value * closeRate
+			tt.expense += expense * closeRate
+			if pinfo.Ins.Type != schema.InsTypeEtf {
+				tt.alpha += po.Alpha().Value * closeRate
+			}
+
+			// the result is taken out at the close
+			tt.xirr.AddScaled(xirr, rate)
+			tt.xirr.AddPayment(-result*closeRate, po.Close.Date)
+			if po.Close.Date.After(tt.last) {
+				tt.last = po.Close.Date
+			}
+		}
+	}
+
+	return totals
+}
+
+func (p *Portfolio) printTags(at time.Time) {
+	totals := p.tagTotals(at)
+
+	fmt.Println("== Tags ==")
+	for _, tag := range p.tagsOrdered() {
+		tt := totals[tag]
+		if tt == nil || tt.expense == 0 {
+			continue
+		}
+
+		xirr := "  n/a"
+		if rate, err := tt.annual(); err == nil {
+			xirr = fmt.Sprintf("%5.1f%%", rate)
+		}
+
+		fmt.Printf("  %-16s %8.0f -> %8.0f : %7.0f (%5.1f%%, annual %s), alpha %.0f\n",
+			tag, tt.expense, tt.value, tt.value-tt.expense, tt.yield(), xirr, tt.alpha)
+	}
+}
+
+func tagHead(tags []string) (head []string) {
+	for _, tag := range tags {
+		head = append(head, "tag."+tag, "tag."+tag+".yield", "tag."+tag+".alpha", "tag."+tag+".xirr")
+	}
+	return
+}
+
+// tagColumns are the open values of the tags at t, with their yields, alphas and xirrs
+func (p *Portfolio) tagColumns(t time.Time, format string) string {
+	totals := p.tagTotals(t)
+
+	s := ""
+	for i, tag := range p.tagsOrdered() {
+		tt := totals[tag]
+		if tt == nil {
+			tt = &tagTotal{}
+		}
+		rate, err := tt.annual()
+
+		if format == schema.TableStyle {
+			s += fmt.Sprintf(", %.0f, %.1f, %.0f, %.1f", tt.open, tt.yield(), tt.alpha, rate)
+			continue
+		}
+
+		xirr := "n/a"
+		if err == nil {
+			xirr = fmt.Sprintf("%.1f%%", rate)
+		}
+		if i > 0 {
+			s += "; "
+		}
+		s += fmt.Sprintf("%s: %.0f (%.1f%%, annual %s, alpha %.0f)", tag, tt.open, tt.yield(), xirr, tt.alpha)
+	}
+	return s
+}
+
+// dealTags tags the operation for deals, and returns the tags to total it by
+func (p *Portfolio) dealTags(op *schema.Operation) []string {
+	if op.Figi == "" {
+		return nil
+	}
+
+	tags := append([]string{}, op.Tags...)
+	if len(p.tagRules) > 0 {
+		tag := p.tagOf(p.insByFigi(op.Figi), op.DateParsed)
+		if tag != untagged {
+			op.Tags = append(op.Tags, tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func printTagTotals(totals map[string]*schema.Balance) {
+	var tags []string
+	for tag := range totals {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	fmt.Printf("   by tags:\n")
+	for _, tag := range tags {
+		fmt.Printf("\t %-16s %s\n", tag, totals[tag].Assets)
+	}
+}
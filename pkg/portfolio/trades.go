@@ -0,0 +1,163 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../schema"
+)
+
+// trade is a closed portion, a round trip from the first deal to the close
+type trade struct {
+	Ticker   string
+	Currency string
+	IsShort  bool
+
+	Entry, Exit time.Time
+	Days        int
+
+	EntryPrice, ExitPrice float64 // average
+	Quantity              int
+
+	Gross, Net       float64 // position currency
+	GrossRub, NetRub float64
+	Yield            float64 // %
+	Annual           float64 // %
+	Alpha            float64 // position currency
+}
+
+// averages of the opening and closing deals
+func portionPrices(po *schema.Portion) (entry, exit float64, quantity int) {
+	var inValue, outValue float64
+	var in, out int
+
+	deals := append(append([]schema.Deal{}, po.Buys...), po.Close)
+	for _, deal := range deals {
+		value := deal.Price.Value * float64(deal.Quantity)
+		if deal.IsBuy() != po.IsShort {
+			inValue += value
+			in += deal.Quantity
+		} else {
+			outValue += value
+			out += deal.Quantity
+		}
+	}
+
+	if in != 0 {
+		entry = inValue / float64(in)
+	}
+	if out != 0 {
+		exit = outValue / float64(out)
+	}
+	return entry, exit, int(math.Abs(float64(in)))
+}
+
+func (p *Portfolio) makeTrade(pinfo *schema.PositionInfo, po *schema.Portion) trade {
+	t := trade{
+		Ticker:   pinfo.Ins.Ticker,
+		Currency: pinfo.Ins.Currency,
+		IsShort:  po.IsShort,
+		Entry:    po.Buys[0].Date,
+		Exit:     po.Close.Date,
+		Net:      po.Balance.Value,
+		Yield:    po.Yield,
+		Annual:   po.YieldAnnual,
+		Alpha:    po.Alpha().Value,
+	}
+	t.Days = int(t.Exit.Sub(t.Entry).Hours() / 24)
+	t.EntryPrice, t.ExitPrice, t.Quantity = portionPrices(po)
+
+	commissions := po.Close.Commission
+	for _, deal := range po.Buys {
+		commissions += deal.Commission
+	}
+	// commissions are negative
+	t.Gross = t.Net - commissions
+
+	rate := p.cc.Xchgrate(t.Currency, "RUB", t.Exit)
+	t.GrossRub = t.Gross * rate
+	t.NetRub = t.Net * rate
+
+	return t
+}
+
+func sortTrades(trades []trade, sortBy string) {
+	less := map[string]func(a, b trade) bool{
+		"date":   func(a, b trade) bool { return a.Exit.Before(b.Exit) },
+		"ticker": func(a, b trade) bool { return a.Ticker < b.Ticker },
+		"amount": func(a, b trade) bool { return a.NetRub > b.NetRub },
+		"yield":  func(a, b trade) bool { return a.Annual > b.Annual },
+		"days":   func(a, b trade) bool { return a.Days > b.Days },
+	}[sortBy]
+	if less == nil {
+		log.Fatalf("unknown sort %s", sortBy)
+	}
+
+	sort.SliceStable(trades, func(i, j int) bool {
+		return less(trades[i], trades[j])
+	})
+}
+
+// Trades lists the round trips closed within the dates
+func (p *Portfolio) Trades(start, end time.Time, sortBy, format string) {
+	p.Collect(end)
+
+	var trades tradeReport
+	for _, pinfo := range p.positions {
+		if pinfo.Ins.Figi == schema.FigiUSD {
+			continue
+		}
+		for _, po := range pinfo.Portions {
+			if !po.IsClosed || len(po.Buys) == 0 || po.Close.Date.Before(start) {
+				continue
+			}
+			trades = append(trades, p.makeTrade(pinfo, po))
+		}
+	}
+
+	sortTrades(trades, sortBy)
+
+	printReport(trades, format)
+}
+
+type tradeReport []trade
+
+func (trades tradeReport) printHuman() {
+	fmt.Println("== Trades ==")
+	total, wins := 0.0, 0
+	for _, t := range trades {
+		short := ""
+		if t.IsShort {
+			short = " short"
+		}
+		fmt.Printf("%-6s%s %s -> %s (%4d days): %4d x %9.2f -> %9.2f, gross %9.2f, net %9.2f %s (%9.0f, %9.0f RUB), "+
+			"%5.1f%%, annual %6.1f%%, alpha %.2f\n",
+			t.Ticker, short, t.Entry.Format("2006/01/02"), t.Exit.Format("2006/01/02"), t.Days,
+			t.Quantity, t.EntryPrice, t.ExitPrice, t.Gross, t.Net, t.Currency, t.GrossRub, t.NetRub,
+			t.Yield, t.Annual, t.Alpha)
+
+		total += t.NetRub
+		if t.Net > 0 {
+			wins++
+		}
+	}
+
+	if len(trades) > 0 {
+		fmt.Printf("total: %.0f RUB, %d of %d won\n", total, wins, len(trades))
+	}
+}
+
+func (trades tradeReport) printTable() {
+	fmt.Println("ticker, currency, short, entry, exit, days, quantity, entry.price, exit.price, " +
+		"gross, net, gross.rub, net.rub, yield, annual, alpha")
+	for _, t := range trades {
+		fmt.Printf("%s, %s, %t, %s, %s, %d, %d, %.4f, %.4f, %.2f, %.2f, %.2f, %.2f, %.1f, %.1f, %.2f\n",
+			t.Ticker, t.Currency, t.IsShort,
+			t.Entry.Format("2006/01/02"), t.Exit.Format("2006/01/02"), t.Days, t.Quantity,
+			t.EntryPrice, t.ExitPrice, t.Gross, t.Net, t.GrossRub, t.NetRub, t.Yield, t.Annual, t.Alpha)
+	}
+}
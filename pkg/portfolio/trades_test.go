@@ -0,0 +1,29 @@
+package portfolio
+
+import (
+	"testing"
+
+	"../schema"
+)
+
+func TestPortionPrices(t *testing.T) {
+	deal := func(quantity int, price float64) schema.Deal {
+		return schema.Deal{Price: schema.NewCValue(price, "RUB"), Quantity: quantity}
+	}
+
+	// the spare capacity must not be written to
+	buys := make([]schema.Deal, 2, 3)
+	buys[0], buys[1] = deal(10, 100), deal(10, 120)
+	spare := buys[:3]
+	spare[2] = deal(1, 1)
+
+	po := &schema.Portion{Buys: buys, Close: deal(-20, 130), IsClosed: true}
+	entry, exit, quantity := portionPrices(po)
+
+	if entry != 110 || exit != 130 || quantity != 20 {
+		t.Errorf("got %d: %.2f -> %.2f, expected 20: 110 -> 130", quantity, entry, exit)
+	}
+	if spare[2].Quantity != 1 {
+		t.Errorf("the buys are overwritten: %v", spare[2])
+	}
+}
@@ -0,0 +1,182 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"../schema"
+)
+
+type unrealizedPosition struct {
+	Ticker   string
+	Currency string
+	Quantity int
+	Price    float64 // current
+
+	// position currency
+	Cost       float64 // commissions included
+	CostNoFees float64
+	Income     float64 // paid on the units still held, taxes deducted
+	BreakEven  float64 // per unit, income included
+	ToBreak    float64 // %, the price move to break even
+	Pnl        float64 // income included
+
+	// RUB, they sum up to PnlRub
+	PriceEffect  float64 // at the rates of the purchases, commissions included
+	FxEffect     float64 // the current value revalued since the purchases
+	IncomeEffect float64 // at the rates of the payments
+	PnlRub       float64
+}
+
+// purchaseRate is the exchange rate the open lot was bought at.
+// The averaged lot merges all the purchases, so their rates are weighted by quantity.
+func purchaseRate(pinfo *schema.PositionInfo, lot *schema.Lot, rate func(time.Time) float64) float64 {
+	if pinfo.CostBasis != schema.CostBasisAverage {
+		return rate(lot.Date)
+	}
+
+	q, avg := 0, 0.0
+	for _, deal := range pinfo.Deals {
+		if deal.Quantity == 0 {
+			continue
+		}
+		switch {
+		case q == 0 || q > 0 && deal.Quantity > 0 || q < 0 && deal.Quantity < 0:
+			avg = (avg*math.Abs(float64(q)) + rate(deal.Date)*math.Abs(float64(deal.Quantity))) /
+				math.Abs(float64(q+deal.Quantity))
+			q += deal.Quantity
+		case math.Abs(float64(deal.Quantity)) < math.Abs(float64(q)):
+			// a partial close keeps the average
+			q += deal.Quantity
+		default:
+			// closed or flipped, whatever remains is bought now
+			q += deal.Quantity
+			avg = rate(deal.Date)
+		}
+	}
+	return avg
+}
+
+// heldShare is the part of the units held just before t that are still held in the open lots
+func heldShare(pinfo *schema.PositionInfo, t time.Time) float64 {
+	before := math.Abs(float64(pinfo.QuantityAt(t)))
+	if before == 0 {
+		return 0
+	}
+
+	held := 0.0
+	for _, lot := range pinfo.Lots {
+		if pinfo.CostBasis == schema.CostBasisAverage {
+			// the averaged units are all alike
+			held += math.Abs(float64(lot.Quantity))
+		} else if lot.Date.Before(t) {
+			held += math.Abs(float64(lot.Quantity))
+		}
+	}
+	return math.Min(held, before) / before
+}
+
+func newUnrealizedPosition(pinfo *schema.PositionInfo, at time.Time, rate func(time.Time) float64) *unrealizedPosition {
+	up := &unrealizedPosition{
+		Ticker:   pinfo.Ins.Ticker,
+		Currency: pinfo.Ins.Currency,
+		Price:    pinfo.OpenDeal.Price.Value,
+	}
+
+	for _, lot := range pinfo.Lots {
+		q := float64(lot.Quantity)
+		up.Quantity += lot.Quantity
+		up.Cost += lot.Price * q
+		// commissions are negative, and reduce the proceeds of the short sells
+		up.CostNoFees += lot.Price*q + lot.Commission*math.Abs(q)
+
+		r := purchaseRate(pinfo, lot, rate)
+		up.PriceEffect += (up.Price - lot.Price) * q * r
+		up.FxEffect += up.Price * q * (rate(at) - r)
+	}
+
+	// only the payments on the units still held
+	for _, div := range pinfo.Dividends {
+		if div.Date.After(at) {
+			continue
+		}
+		value := div.Value * heldShare(pinfo, div.Date)
+		up.Income += value
+		up.IncomeEffect += value * rate(div.Date)
+	}
+
+	if up.Quantity != 0 {
+		up.BreakEven = (up.Cost - up.Income) / float64(up.Quantity)
+	}
+	if up.Price != 0 {
+		up.ToBreak = 100 * (up.BreakEven - up.Price) / up.Price
+	}
+	up.Pnl = up.Price*float64(up.Quantity) - up.Cost + up.Income
+	up.PnlRub = up.PriceEffect + up.FxEffect + up.IncomeEffect
+
+	return up
+}
+
+func (p *Portfolio) unrealizedPosition(pinfo *schema.PositionInfo, at time.Time) *unrealizedPosition {
+	return newUnrealizedPosition(pinfo, at, func(t time.Time) float64 {
+		return p.cc.Xchgrate(pinfo.Ins.Currency, "RUB", t)
+	})
+}
+
+type unrealizedReport []*unrealizedPosition
+
+func (ups unrealizedReport) printHuman() {
+	fmt.Println("== Unrealized ==")
+
+	total := make(map[string]float64)
+	var totalRub unrealizedPosition
+	for _, up := range ups {
+		fmt.Printf("%-6s %5d x %9.2f %s: cost %10.2f (%10.2f w/o fees), income %9.2f, "+
+			"break-even %9.2f (%+6.1f%%), P&L %10.2f\n",
+			up.Ticker, up.Quantity, up.Price, up.Currency, up.Cost, up.CostNoFees, up.Income,
+			up.BreakEven, up.ToBreak, up.Pnl)
+		fmt.Printf("       RUB: price %10.0f, fx %10.0f, income %9.0f = %10.0f\n",
+			up.PriceEffect, up.FxEffect, up.IncomeEffect, up.PnlRub)
+
+		total[up.Currency] += up.Pnl
+		totalRub.PriceEffect += up.PriceEffect
+		totalRub.FxEffect += up.FxEffect
+		totalRub.IncomeEffect += up.IncomeEffect
+		totalRub.PnlRub += up.PnlRub
+	}
+
+	for _, cur := range schema.CurrenciesOrdered {
+		if pnl, ok := total[cur]; ok {
+			fmt.Printf("total %s: %.2f\n", cur, pnl)
+		}
+	}
+	fmt.Printf("total RUB: price %.0f, fx %.0f, income %.0f = %.0f\n",
+		totalRub.PriceEffect, totalRub.FxEffect, totalRub.IncomeEffect, totalRub.PnlRub)
+}
+
+func (ups unrealizedReport) printTable() {
+	fmt.Println("ticker, currency, quantity, price, cost, cost.nofees, income, break.even, to.break, pnl, " +
+		"price.effect, fx.effect, income.effect, pnl.rub")
+	for _, up := range ups {
+		fmt.Printf("%s, %s, %d, %.4f, %.2f, %.2f, %.2f, %.4f, %.1f, %.2f, %.2f, %.2f, %.2f, %.2f\n",
+			up.Ticker, up.Currency, up.Quantity, up.Price, up.Cost, up.CostNoFees, up.Income,
+			up.BreakEven, up.ToBreak, up.Pnl, up.PriceEffect, up.FxEffect, up.IncomeEffect, up.PnlRub)
+	}
+}
+
+// Unrealized breaks the result of every open position down
+// into the price, currency and income effects, and shows its break-even price
+func (p *Portfolio) Unrealized(at time.Time, format string) {
+	p.Collect(at)
+
+	var ups unrealizedReport
+	p.forSortedPositions(func(pinfo *schema.PositionInfo) {
+		if pinfo.IsClosed() || pinfo.Ins.Figi == schema.FigiUSD || len(pinfo.Lots) == 0 {
+			return
+		}
+		ups = append(ups, p.unrealizedPosition(pinfo, at))
+	})
+
+	printReport(ups, format)
+}
@@ -0,0 +1,113 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"../schema"
+)
+
+func TestUnrealizedPosition(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	op := func(d int, typ string, quantity int, price, payment float64) schema.Operation {
+		op := schema.Operation{
+			OperationType: typ,
+			Status:        "Done",
+			Currency:      "USD",
+			DateParsed:    day(d),
+			Price:         price,
+			Payment:       payment,
+		}
+		if quantity != 0 {
+			op.Trades = []schema.Trade{{Price: price, Quantity: uint(quantity)}}
+		}
+		return op
+	}
+	buy := func(d, quantity int, price float64) schema.Operation {
+		return op(d, "Buy", quantity, price, -price*float64(quantity))
+	}
+	sell := func(d, quantity int, price float64) schema.Operation {
+		return op(d, "Sell", quantity, price, price*float64(quantity))
+	}
+	dividend := func(d int, value float64) schema.Operation {
+		return op(d, "Dividend", 0, 0, value)
+	}
+	// the rate grows by 1 a day, from 71
+	rate := func(t time.Time) float64 {
+		return 70 + float64(t.Day())
+	}
+
+	tests := []struct {
+		name      string
+		costBasis schema.CostBasis
+		ops       []schema.Operation
+		quantity  int
+		cost      float64
+		income    float64
+		breakEven float64
+		priceRub  float64
+		fxRub     float64
+		incomeRub float64
+	}{
+		{
+			name:      "fifo",
+			costBasis: schema.CostBasisFifo,
+			ops: []schema.Operation{buy(1, 10, 100), dividend(2, 10), buy(3, 10, 120), dividend(4, 20),
+				sell(5, 10, 130)},
+			quantity: 10,
+			cost:     1200,
+			// the first payment went to the sold units only, half of the second one is left
+			income:    10,
+			breakEven: 119,
+			priceRub:  (150 - 120) * 10 * 73,
+			fxRub:     150 * 10 * (80 - 73),
+			incomeRub: 10 * 74,
+		},
+		{
+			name:      "average",
+			costBasis: schema.CostBasisAverage,
+			ops: []schema.Operation{buy(1, 10, 100), dividend(2, 10), buy(3, 30, 120), dividend(4, 40),
+				sell(5, 20, 130)},
+			quantity:  20,
+			cost:      2300,
+			income:    10 + 20,
+			breakEven: 113.5,
+			// bought at (71 x 10 + 73 x 30) / 40
+			priceRub:  (150 - 115) * 20 * 72.5,
+			fxRub:     150 * 20 * (80 - 72.5),
+			incomeRub: 10*72 + 20*74,
+		},
+	}
+
+	for _, tt := range tests {
+		pinfo := &schema.PositionInfo{
+			Ins:       schema.Instrument{Ticker: "T", Currency: "USD"},
+			CostBasis: tt.costBasis,
+		}
+		for _, op := range tt.ops {
+			pinfo.AddOperation(op)
+		}
+		pinfo.OpenDeal = schema.Deal{Date: day(10), Price: schema.NewCValue(150, "USD"), Quantity: -tt.quantity}
+
+		up := newUnrealizedPosition(pinfo, day(10), rate)
+
+		near := func(a, b float64) bool {
+			return math.Abs(a-b) < 1e-6
+		}
+		if up.Quantity != tt.quantity || !near(up.Cost, tt.cost) || !near(up.Income, tt.income) ||
+			!near(up.BreakEven, tt.breakEven) {
+			t.Errorf("%s: got %d for %.2f, income %.2f, break-even %.2f, expected %d for %.2f, income %.2f, break-even %.2f",
+				tt.name, up.Quantity, up.Cost, up.Income, up.BreakEven, tt.quantity, tt.cost, tt.income, tt.breakEven)
+		}
+		if !near(up.PriceEffect, tt.priceRub) || !near(up.FxEffect, tt.fxRub) || !near(up.IncomeEffect, tt.incomeRub) {
+			t.Errorf("%s: got price %.2f, fx %.2f, income %.2f, expected %.2f, %.2f, %.2f", tt.name,
+				up.PriceEffect, up.FxEffect, up.IncomeEffect, tt.priceRub, tt.fxRub, tt.incomeRub)
+		}
+		if !near(up.PnlRub, up.PriceEffect+up.FxEffect+up.IncomeEffect) {
+			t.Errorf("%s: P&L %.2f is not the sum of the effects", tt.name, up.PnlRub)
+		}
+	}
+}
@@ -1,14 +1,38 @@
 package portfolio
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"../aux"
 	"../schema"
 )
 
+// report is printed as is for the json format
+type report interface {
+	printHuman()
+	printTable()
+}
+
+func printReport(r report, format string) {
+	switch format {
+	case "human":
+		r.printHuman()
+	case "json":
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	default:
+		r.printTable()
+	}
+}
+
 func (p *Portfolio) Print(at time.Time) {
 	fmt.Println("== Totals ==")
 
@@ -17,6 +41,18 @@ func (p *Portfolio) Print(at time.Time) {
 	fmt.Printf(" alpha: %s (%.1f%%)\n",
 		p.alphas, aux.Ratio2Perc(p.alphaCorrectedAssets()/p.payins()))
 
+	if p.replay != nil {
+		bench := p.replay.value(at)
+		fmt.Printf(" vs benchmark %s: %s, ahead by %.0f\n",
+			p.config.benchmark, p.replay.String(at, ""), p.assets()-bench)
+	}
+
+	if len(p.tagRules) > 0 {
+		p.printTags(at)
+	}
+
+	p.printFx(at)
+
 	fmt.Println("== Current positions ==")
 	p.forSortedPositions(func(pinfo *schema.PositionInfo) {
 		if pinfo.IsClosed() {
@@ -0,0 +1,123 @@
+package portfolio
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		T string `xml:"t"`
+		R []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readZipFile(zr *zip.ReadCloser, name string) []byte {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rc.Close()
+
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return data
+	}
+	return nil
+}
+
+// "AB12" -> 27
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A') + 1
+	}
+	return col - 1
+}
+
+// readXlsx returns the rows of the first sheet as strings
+func readXlsx(fname string) (rows [][]string) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer zr.Close()
+
+	var shared xlsxSharedStrings
+	if data := readZipFile(zr, "xl/sharedStrings.xml"); data != nil {
+		if err := xml.Unmarshal(data, &shared); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var sheet xlsxSheet
+	data := readZipFile(zr, "xl/worksheets/sheet1.xml")
+	if data == nil {
+		log.Fatalf("%s: no sheets", fname)
+	}
+	if err := xml.Unmarshal(data, &sheet); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, r := range sheet.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				col = xlsxColumn(c.Ref)
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx >= len(shared.Items) {
+					log.Fatalf("%s: bad shared string %s", fname, c.Value)
+				}
+				si := shared.Items[idx]
+				s := si.T
+				for _, run := range si.R {
+					s += run.T
+				}
+				row[col] = s
+			case "inlineStr":
+				row[col] = c.Inline
+			default:
+				row[col] = c.Value
+			}
+			row[col] = strings.TrimSpace(row[col])
+		}
+		rows = append(rows, row)
+	}
+
+	return
+}
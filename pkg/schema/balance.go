@@ -2,6 +2,7 @@ package schema
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -63,6 +64,7 @@ type Balance struct {
 	Commissions, Payins, Assets CurMap
 
 	xirr aux.XirrCtx
+	twr  aux.TwrCtx
 }
 
 func NewBalance() *Balance {
@@ -89,6 +91,7 @@ func (b Balance) Foreach(f func(string, CurMap)) {
 func (b Balance) Copy() *Balance {
 	copy := NewBalance()
 	copy.xirr = b.xirr
+	copy.twr = b.twr
 
 	for _, cur := range balanceMaps() {
 		copy.Payins[cur] = b.Payins[cur].Copy()
@@ -101,7 +104,7 @@ func (b Balance) Copy() *Balance {
 
 func (b Balance) hasPayins() bool {
 	for _, cv := range b.Payins {
-		if cv.Value > 0 {
+		if cv.Value != 0 {
 			return true
 		}
 	}
@@ -124,6 +127,11 @@ func (b *Balance) Add(b2 Balance) {
 	}
 }
 
+// twr is calculated outside, as it needs snapshots of the whole portfolio
+func (b *Balance) SetTwr(twr aux.TwrCtx) {
+	b.twr = twr
+}
+
 func (b *Balance) CalcAllAssets(usd, eur float64) float64 {
 	return b.Assets.CalcAll(usd, eur)
 }
@@ -137,54 +145,82 @@ func (b *Balance) CalcAllAssets(usd, eur float64) float64 {
  USD
  + Assets:
     1. Cash balance
-        1.1 Direct payins
+        1.1 Direct payins (and withdrawals, card buys, securities in & out)
         1.2 Exchanges
         1.3 Sold stocks
         1.4 - Bought stocks
-        1.5 - Service commissions
-        1.6 - Tax
+        1.5 - Service, exchange, margin & other commissions
+        1.6 - Tax (+ tax back)
         1.7 Dividends, coupons & repayments
-    2. Open USD positions
+    2. Open USD positions (negative for shorts)
  - Payins
-    3. Directs payins
+    3. Directs payins (- withdrawals)
     4. Exchanges
 
 RUB
  + Assets:
     1. Cash balance
-        1.1 Direct payins
+        1.1 Direct payins (and withdrawals, card buys, securities in & out)
         1.3 Sold stocks & dollars
         1.4 - Bought stocks & dollars
-        1.5 - Service commissions
-        1.6 - Tax
+        1.5 - Service, exchange, margin & other commissions
+        1.6 - Tax (+ tax back)
         1.7 Dividends, coupons & repayments
-    2. Open RUB positions
+    2. Open RUB positions (negative for shorts)
  - Payins:
-    3. Direct payins
+    3. Direct payins (- withdrawals)
     5. - Exchanged money
 
 */
 
+// value is negative for withdrawals
+func (bal *Balance) addPayin(op Operation, value float64, xchgrate func(curr_from, curr_to string, t time.Time) float64) {
+	// 1.1
+	bal.Assets[op.Currency].Value += value
+
+	if op.IsTransfer {
+		// the money stays with us
+		return
+	}
+
+	// 3
+	bal.Payins[op.Currency].Value += value
+
+	// add total payin
+	payin := value * xchgrate(op.Currency, "RUB", op.DateParsed)
+	bal.xirr.AddPayment(payin, op.DateParsed)
+	bal.Payins["all"].Value += payin
+}
+
 func (bal *Balance) AddOperation(op Operation, xchgrate func(curr_from, curr_to string, t time.Time) float64) {
-	if op.IsTrading() || op.OperationType == "BrokerCommission" {
+	switch op.Kind() {
+	case OpTrade, OpRepayment, OpBrokerCommission:
 		// not accounted here
 
-	} else if op.IsPayment() {
-		// 1.7
-		bal.Assets[op.Currency].Value += op.Payment
-	} else if op.OperationType == "PayIn" {
-		// 1.1
+	case OpTradeCard:
+		// the deal is accounted in AddDeal, but the money comes from the card
+		bal.addPayin(op, -op.Payment, xchgrate)
+
+	case OpSecurityIn:
+		// the deal is accounted in AddDeal, the securities are a payin themselves
+		bal.addPayin(op, op.Price*float64(op.Quantity()), xchgrate)
+
+	case OpSecurityOut:
+		// negative
+		bal.addPayin(op, op.Price*float64(op.Quantity()), xchgrate)
+
+	case OpIncome, OpPositionTax, OpTax, OpTaxBack:
+		// 1.6, 1.7
 		bal.Assets[op.Currency].Value += op.Payment
-		// 3
-		bal.Payins[op.Currency].Value += op.Payment
 
-		// add total payin
-		payin := op.Payment * xchgrate(op.Currency, "RUB", op.DateParsed)
-		bal.xirr.AddPayment(payin, op.DateParsed)
-		bal.Payins["all"].Value += payin
+	case OpPayIn:
+		bal.addPayin(op, op.Payment, xchgrate)
 
-	} else if op.OperationType == "ServiceCommission" {
+	case OpPayOut:
+		// negative
+		bal.addPayin(op, op.Payment, xchgrate)
 
+	case OpCommission:
 		bal.Commissions[op.Currency].Value += op.Payment
 		// add total
 		bal.Commissions["all"].Value += op.Payment * xchgrate(op.Currency, "RUB", op.DateParsed)
@@ -192,10 +228,7 @@ func (bal *Balance) AddOperation(op Operation, xchgrate func(curr_from, curr_to
 		// 1.5
 		bal.Assets[op.Currency].Value -= -op.Payment
 
-	} else if op.OperationType == "Tax" {
-		// 1.6
-		bal.Assets[op.Currency].Value -= -op.Payment
-	} else {
+	default:
 		log.Warnf("Unprocessed transaction 2 %v", op)
 	}
 }
@@ -219,12 +252,15 @@ func (bal *Balance) AddDeal(deal Deal, figi string) {
 type SectionedBalance struct {
 	Sections map[Section]*Balance
 	Total    *Balance
+
+	Ordered []Section // printed
 }
 
-func NewSectionedBalance() SectionedBalance {
+func NewSectionedBalance(ordered []Section) SectionedBalance {
 	return SectionedBalance{
 		Sections: make(map[Section]*Balance),
 		Total:    NewBalance(),
+		Ordered:  ordered,
 	}
 }
 
@@ -237,8 +273,10 @@ func (sb SectionedBalance) SectionBalance(section Section) *Balance {
 	return b
 }
 
-func (sb SectionedBalance) AddDeal(deal Deal, figi string, section Section) {
-	sb.SectionBalance(section).AddDeal(deal, figi)
+func (sb SectionedBalance) AddDeal(deal Deal, figi string, weights map[Section]float64) {
+	for section, w := range weights {
+		sb.SectionBalance(section).AddDeal(deal.Scale(w), figi)
+	}
 	sb.Total.AddDeal(deal, figi)
 }
 
@@ -263,39 +301,74 @@ const (
 	TableStyle = "table"
 )
 
-func PrintBalanceHead(style string) {
+// extra columns are appended to the table head
+func PrintBalanceHead(style string, sections []Section, extra ...string) {
 	if style == TableStyle {
-		fmt.Println("payins, assets, delta, bonds.rub, bonds.usd, stocks.rub, stocks.usd, pivotdate")
+		s := "payins, assets, delta, twr, twr.period"
+		for _, section := range sections {
+			s += ", " + strings.ToLower(string(section))
+		}
+		for _, col := range extra {
+			s += ", " + col
+		}
+		fmt.Println(s + ", pivotdate")
+	}
+}
+
+func (sb SectionedBalance) sharesString(style string) string {
+	s := ""
+	for i, section := range sb.Ordered {
+		if style == TableStyle {
+			s += fmt.Sprintf(", %.1f", sb.sectionShare(section))
+			continue
+		}
+
+		if i > 0 {
+			s += "; "
+		}
+		s += fmt.Sprintf("%s: %4.1f%%", section, sb.sectionShare(section))
 	}
+	return s
+}
+
+func xirrString(xirr aux.XirrCtx, result float64, t time.Time) string {
+	rate, err := xirr.Ratio(result, t)
+	if err != nil {
+		return "  n/a"
+	}
+	return fmt.Sprintf("%5.1f%%", rate*100)
 }
 
 func (b SectionedBalance) Print(t time.Time, prefix, style string) {
+	fmt.Println(b.String(t, prefix, style))
+}
+
+func (b SectionedBalance) String(t time.Time, prefix, style string) string {
 	p, a := b.Total.Payins["all"].Value, b.Total.Assets["all"].Value
 	d := a - p
+	twr := b.Total.twr
 
 	s := ""
 	if style == TableStyle {
 		if prefix != "" {
 			s = prefix + ", "
 		}
-		s += fmt.Sprintf("%.0f, %.0f, %.0f, %.1f, %.1f, %.1f, %.1f",
+		s += fmt.Sprintf("%.0f, %.0f, %.0f, %.1f, %.1f",
 			p, a, d,
-			b.sectionShare(BondRub),
-			b.sectionShare(BondUsd),
-			b.sectionShare(StockRub),
-			b.sectionShare(StockUsd))
+			aux.Ratio2Perc(twr.Ratio()), aux.Ratio2Perc(twr.Last()))
+		s += b.sharesString(style)
 	} else {
 		if prefix != "" {
 			s = prefix + ": "
 		}
-		s += fmt.Sprintf("%7.0f -> %7.0f : %6.0f (%5.1f%%, annual %5.1f%%) "+
-			"bonds(R+U): %5.1f + %5.1f%%; stocks: %5.1f+%5.1f%%",
+		s += fmt.Sprintf("%7.0f -> %7.0f : %6.0f (%5.1f%%, annual %s",
 			p, a, d,
-			aux.Ratio2Perc(a/p), b.Total.xirr.Ratio(a, t)*100,
-			b.sectionShare(BondRub),
-			b.sectionShare(BondUsd),
-			b.sectionShare(StockRub),
-			b.sectionShare(StockUsd))
+			aux.Ratio2Perc(a/p), xirrString(b.Total.xirr, a, t))
+		if twr.Valid() {
+			s += fmt.Sprintf("; twr %5.1f%%, annual %5.1f%%",
+				aux.Ratio2Perc(twr.Ratio()), aux.Ratio2Perc(twr.Annual(t)))
+		}
+		s += ") " + b.sharesString(style)
 	}
-	fmt.Println(s)
+	return s
 }
@@ -11,6 +11,7 @@ type Deal struct {
 	Quantity   int     // positive for Buy
 	Accrued    float64 // aka NKD
 	Commission float64 // negative
+	MarginCall bool
 }
 
 func (deal Deal) IsBuy() bool {
@@ -28,12 +29,32 @@ func (deal Deal) Profit() float64 {
 }
 
 func (deal Deal) String() string {
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"%s: (%.2f x %d) = %s",
 		deal.Date.Format("2006/01/02"),
 		deal.Price.Value,
 		deal.Quantity,
 		deal.CValue())
+	if deal.MarginCall {
+		s += " margin call"
+	}
+	return s
+}
+
+// Split cuts the deal in two, the first one gets the quantity q
+func (deal Deal) Split(q int) (Deal, Deal) {
+	first, second := deal, deal
+	share := float64(q) / float64(deal.Quantity)
+
+	first.Quantity = q
+	first.Accrued *= share
+	first.Commission *= share
+
+	second.Quantity -= q
+	second.Accrued -= first.Accrued
+	second.Commission -= first.Commission
+
+	return first, second
 }
 
 func (deal Deal) Value() float64 {
@@ -45,3 +66,11 @@ func (deal Deal) CValue() CValue {
 	cv.Value += deal.Accrued
 	return cv
 }
+
+// Scale multiplies the deal value keeping the quantity
+func (deal Deal) Scale(m float64) Deal {
+	deal.Price = deal.Price.Mult(m)
+	deal.Accrued *= m
+	deal.Commission *= m
+	return deal
+}
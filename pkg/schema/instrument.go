@@ -1,10 +1,11 @@
 package schema
 
 import (
-	log "github.com/sirupsen/logrus"
-
+	"fmt"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
+
 	"../aux"
 )
 
@@ -28,6 +29,100 @@ const (
 	CashUsd          = "Cash.USD"
 )
 
+// Sections classifies the instruments into asset classes
+type Sections struct {
+	Ordered []Section                      // printed
+	Assign  map[string]Section             // key=ticker or figi
+	Split   map[string]map[Section]float64 // mixed funds, key=ticker or figi
+}
+
+// DefaultSections are bonds and stocks by currencies
+func DefaultSections() *Sections {
+	return &Sections{
+		Ordered: []Section{BondRub, BondUsd, StockRub, StockUsd},
+	}
+}
+
+// Validate tells if every assigned or split section is printed,
+// and every split has some weight and none of them is negative
+func (ss Sections) Validate() error {
+	for key, section := range ss.Assign {
+		if !ss.isOrdered(section) {
+			return fmt.Errorf("%s: %s is not in the sections", key, section)
+		}
+	}
+
+	for key, split := range ss.Split {
+		total := 0.0
+		for section, w := range split {
+			if !ss.isOrdered(section) {
+				return fmt.Errorf("%s: %s is not in the sections", key, section)
+			}
+			if w < 0 {
+				return fmt.Errorf("%s: negative weight of %s", key, section)
+			}
+			total += w
+		}
+		if total <= 0 {
+			return fmt.Errorf("%s: no weights", key)
+		}
+	}
+	return nil
+}
+
+func (ss Sections) isOrdered(section Section) bool {
+	for _, s := range ss.Ordered {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Of is the assigned section of the instrument, or the default one
+func (ss *Sections) Of(ins Instrument) Section {
+	if ss != nil {
+		if s, ok := ss.Assign[ins.Figi]; ok {
+			return s
+		}
+		if s, ok := ss.Assign[ins.Ticker]; ok {
+			return s
+		}
+	}
+
+	s := getSection(ins)
+	if s == "" && ins.Type == InsTypeEtf {
+		log.Warnf("Uncatched ETF %s, assign it a section in the sections file", ins.Ticker)
+	}
+	return s
+}
+
+// Weights tells how the instrument is split between the sections, sums up to 1
+func (ss *Sections) Weights(ins Instrument) map[Section]float64 {
+	var split map[Section]float64
+	if ss != nil {
+		var ok bool
+		split, ok = ss.Split[ins.Figi]
+		if !ok {
+			split = ss.Split[ins.Ticker]
+		}
+	}
+
+	total := 0.0
+	for _, w := range split {
+		total += w
+	}
+	if total <= 0 {
+		return map[Section]float64{ins.Section: 1}
+	}
+
+	weights := make(map[Section]float64)
+	for s, w := range split {
+		weights[s] = w / total
+	}
+	return weights
+}
+
 // TODO why json tags?
 type Instrument struct {
 	Figi      string `json:"figi"`
@@ -71,7 +166,31 @@ func getInstrumentType(typ string, ticker string) InsType {
 	return InsType(typ)
 }
 
-func (ins Instrument) Benchmark() string {
+// Benchmarks override the default ones per section and per ticker,
+// values are tickers or mixes like "FXUS:0.6,FXRU:0.4"
+type Benchmarks struct {
+	Sections map[Section]string
+	Tickers  map[string]string
+}
+
+// Of returns a ticker or a mix of them, empty if the instrument is a benchmark itself
+func (bs *Benchmarks) Of(ins Instrument) string {
+	if bs != nil {
+		if bench, ok := bs.Tickers[ins.Ticker]; ok {
+			return bench
+		}
+		if bench, ok := bs.Sections[ins.Section]; ok {
+			if bench != ins.Ticker {
+				return bench
+			}
+			return ""
+		}
+	}
+
+	return defaultBenchmark(ins)
+}
+
+func defaultBenchmark(ins Instrument) string {
 	if bench, ok := map[Section]string{
 		BondRub:  "VTBB",
 		BondUsd:  "FXRU",
@@ -114,8 +233,7 @@ func GetEtfSection(ticker string) (Section, bool) {
 		"FXRB": BondRub,
 
 		// T* funds are (25x4 gold, stocks, long and short bonds)
-		// TODO proper accounting
-		// consider them bonds for now
+		// consider them bonds, unless split in the sections file
 		"TRUR": BondRub,
 		"TUSD": BondUsd,
 
@@ -157,10 +275,7 @@ func getSection(ins Instrument) Section {
 	}
 
 	if ins.Type == InsTypeEtf {
-		s, ok := GetEtfSection(ins.Ticker)
-		if !ok {
-			log.Warnf("Uncatched ETF %s", ins.Ticker)
-		}
+		s, _ := GetEtfSection(ins.Ticker)
 		return s
 	}
 
@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSectionWeights(t *testing.T) {
+	ss := &Sections{
+		Assign: map[string]Section{"FXGD": "Gold"},
+		Split: map[string]map[Section]float64{
+			"TRUR": {"Gold": 25, StockRub: 25, BondRub: 50},
+		},
+	}
+
+	tests := []struct {
+		name string
+		ss   *Sections
+		ins  Instrument
+		exp  map[Section]float64
+	}{
+		{
+			name: "default",
+			ss:   DefaultSections(),
+			ins:  Instrument{Ticker: "TRUR", Type: InsTypeEtf, Section: BondRub},
+			exp:  map[Section]float64{BondRub: 1},
+		},
+		{
+			name: "split",
+			ss:   ss,
+			ins:  Instrument{Ticker: "TRUR", Type: InsTypeEtf, Section: BondRub},
+			exp:  map[Section]float64{"Gold": 0.25, StockRub: 0.25, BondRub: 0.5},
+		},
+		{
+			name: "no config",
+			ins:  Instrument{Ticker: "SBER", Type: InsTypeStock, Section: StockRub},
+			exp:  map[Section]float64{StockRub: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		weights := tt.ss.Weights(tt.ins)
+		if len(weights) != len(tt.exp) {
+			t.Errorf("%s: got %v, expected %v", tt.name, weights, tt.exp)
+			continue
+		}
+		for s, w := range tt.exp {
+			if math.Abs(weights[s]-w) > 1e-9 {
+				t.Errorf("%s: got %v, expected %v", tt.name, weights, tt.exp)
+			}
+		}
+	}
+
+	gold := Instrument{Ticker: "FXGD", Type: InsTypeEtf, Currency: "RUB"}
+	if s := ss.Of(gold); s != "Gold" {
+		t.Errorf("assigned %s, expected Gold", s)
+	}
+}
+
+func TestSectionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		assign map[string]Section
+		split  map[Section]float64
+		ok     bool
+	}{
+		{"weights", nil, map[Section]float64{BondRub: 1, StockRub: 3}, true},
+		{"empty", nil, map[Section]float64{}, false},
+		{"zero", nil, map[Section]float64{BondRub: 0, StockRub: 0}, false},
+		{"negative", nil, map[Section]float64{BondRub: 2, StockRub: -1}, false},
+		{"split into unknown", nil, map[Section]float64{BondRub: 1, "Gold": 1}, false},
+		{"assigned", map[string]Section{"FXGD": StockUsd}, nil, true},
+		{"assigned unknown", map[string]Section{"FXGD": "Gold"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		ss := DefaultSections()
+		ss.Assign = tt.assign
+		if tt.split != nil {
+			ss.Split = map[string]map[Section]float64{"FUND": tt.split}
+		}
+		if err := ss.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestBenchmarks(t *testing.T) {
+	bs := &Benchmarks{
+		Sections: map[Section]string{StockRub: "FXRL:0.5,SBMX:0.5", BondRub: "FXRB"},
+		Tickers:  map[string]string{"TSLA": "FXIT"},
+	}
+
+	tests := []struct {
+		name string
+		bs   *Benchmarks
+		ins  Instrument
+		exp  string
+	}{
+		{"default", nil, Instrument{Ticker: "SBER", Section: StockRub}, "FXRL"},
+		{"default tech", nil, Instrument{Ticker: "AAPL", Section: StockUsd}, "FXIT"},
+		{"default itself", nil, Instrument{Ticker: "FXUS", Section: StockUsd}, ""},
+		{"section", bs, Instrument{Ticker: "SBER", Section: StockRub}, "FXRL:0.5,SBMX:0.5"},
+		{"section itself", bs, Instrument{Ticker: "FXRB", Section: BondRub}, ""},
+		{"ticker", bs, Instrument{Ticker: "TSLA", Section: StockUsd}, "FXIT"},
+		{"not configured", bs, Instrument{Ticker: "MSFT", Section: StockUsd}, "FXIT"},
+	}
+
+	for _, tt := range tests {
+		if bench := tt.bs.Of(tt.ins); bench != tt.exp {
+			t.Errorf("%s: got %q, expected %q", tt.name, bench, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type CostBasis string
+
+const (
+	CostBasisFifo     CostBasis = "fifo"
+	CostBasisLifo     CostBasis = "lifo"
+	CostBasisAverage  CostBasis = "average"
+	CostBasisSpecific CostBasis = "specific"
+)
+
+// Lot is a part of a position bought in one go.
+type Lot struct {
+	Date       time.Time
+	Quantity   int     // remaining, positive for Buy
+	Price      float64 // per unit, accrued and commission included
+	Commission float64 // per unit, negative
+}
+
+// LotMatch is a (part of a) lot matched with a closing deal.
+// Unrealized matches are closed with a pretended deal at the current price.
+type LotMatch struct {
+	Open, Close time.Time
+	Quantity    int
+	Cost        float64
+	Proceeds    float64
+}
+
+func (m LotMatch) Profit() float64 {
+	return m.Proceeds - m.Cost
+}
+
+func (m LotMatch) String() string {
+	return fmt.Sprintf("%s -> %s: %d x %.2f -> %.2f = %.2f",
+		m.Open.Format("2006/01/02"), m.Close.Format("2006/01/02"),
+		m.Quantity, m.Cost/float64(m.Quantity), m.Proceeds/float64(m.Quantity), m.Profit())
+}
+
+func sameSign(a, b int) bool {
+	return a > 0 && b > 0 || a < 0 && b < 0
+}
+
+// returns lot indices in the order they are to be consumed
+func (pinfo PositionInfo) lotOrder(deal Deal) []int {
+	idxs := make([]int, len(pinfo.Lots))
+	for i := range idxs {
+		idxs[i] = i
+	}
+
+	switch pinfo.CostBasis {
+	case CostBasisLifo:
+		sort.SliceStable(idxs, func(i, j int) bool {
+			return pinfo.Lots[idxs[i]].Date.After(pinfo.Lots[idxs[j]].Date)
+		})
+	case CostBasisSpecific:
+		picks := pinfo.LotPicks[deal.Date.Format("2006/01/02")]
+		if len(picks) == 0 {
+			log.Warnf("no lots picked for %s sold at %s, using fifo",
+				pinfo.Ins.Ticker, deal.Date.Format("2006/01/02"))
+		}
+
+		rank := func(lot *Lot) int {
+			for r, date := range picks {
+				if lot.Date.Format("2006/01/02") == date {
+					return r
+				}
+			}
+			return len(picks)
+		}
+		sort.SliceStable(idxs, func(i, j int) bool {
+			return rank(pinfo.Lots[idxs[i]]) < rank(pinfo.Lots[idxs[j]])
+		})
+	}
+
+	return idxs
+}
+
+func (pinfo *PositionInfo) addLot(deal Deal) {
+	if deal.Quantity == 0 {
+		return
+	}
+
+	lot := &Lot{
+		Date:       deal.Date,
+		Quantity:   deal.Quantity,
+		Price:      deal.Expense() / float64(deal.Quantity),
+		Commission: deal.Commission / float64(abs(deal.Quantity)),
+	}
+
+	if pinfo.CostBasis == CostBasisAverage && len(pinfo.Lots) > 0 {
+		avg := pinfo.Lots[0]
+		cost := avg.Price*float64(avg.Quantity) + lot.Price*float64(lot.Quantity)
+		commission := avg.Commission*float64(abs(avg.Quantity)) + lot.Commission*float64(abs(lot.Quantity))
+		avg.Quantity += lot.Quantity
+		avg.Price = cost / float64(avg.Quantity)
+		avg.Commission = commission / float64(abs(avg.Quantity))
+		return
+	}
+
+	pinfo.Lots = append(pinfo.Lots, lot)
+}
+
+// matchLots consumes open lots with the closing deal,
+// whatever remains of the deal opens a new lot
+func (pinfo *PositionInfo) matchLots(deal Deal) {
+	if deal.Quantity == 0 {
+		// there are fictive deals with 0 quantity
+		return
+	}
+
+	if len(pinfo.Lots) == 0 || sameSign(pinfo.Lots[0].Quantity, deal.Quantity) {
+		pinfo.addLot(deal)
+		return
+	}
+
+	// per unit, positive for Sell
+	proceeds := deal.Profit() / float64(-deal.Quantity)
+	quantity := deal.Quantity
+
+	for _, idx := range pinfo.lotOrder(deal) {
+		lot := pinfo.Lots[idx]
+		if deal.Quantity == 0 {
+			break
+		}
+
+		n := lot.Quantity
+		if abs(n) > abs(deal.Quantity) {
+			n = -deal.Quantity
+		}
+
+		pinfo.Realized = append(pinfo.Realized, LotMatch{
+			Open:     lot.Date,
+			Close:    deal.Date,
+			Quantity: n,
+			Cost:     lot.Price * float64(n),
+			Proceeds: proceeds * float64(n),
+		})
+
+		lot.Quantity -= n
+		deal.Quantity += n
+	}
+
+	lots := pinfo.Lots[:0]
+	for _, lot := range pinfo.Lots {
+		if lot.Quantity != 0 {
+			lots = append(lots, lot)
+		}
+	}
+	pinfo.Lots = lots
+
+	if deal.Quantity != 0 {
+		// the deal has flipped the position, the commission is shared
+		deal.Accrued = 0
+		deal.Commission *= float64(deal.Quantity) / float64(quantity)
+		pinfo.addLot(deal)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Unrealized pretends every open lot is closed at price
+func (pinfo PositionInfo) Unrealized(date time.Time, price float64) (matches []LotMatch) {
+	for _, lot := range pinfo.Lots {
+		matches = append(matches, LotMatch{
+			Open:     lot.Date,
+			Close:    date,
+			Quantity: lot.Quantity,
+			Cost:     lot.Price * float64(lot.Quantity),
+			Proceeds: price * float64(lot.Quantity),
+		})
+	}
+	return
+}
+
+func (pinfo PositionInfo) lotsString() string {
+	var realized, unrealized float64
+
+	s := fmt.Sprintf("    lots (%s):\n", pinfo.CostBasis)
+
+	for _, m := range pinfo.Realized {
+		s += "      " + m.String() + " realized\n"
+		realized += m.Profit()
+	}
+
+	if !pinfo.IsClosed() {
+		od := pinfo.OpenDeal
+		for _, m := range pinfo.Unrealized(od.Date, od.Price.Value) {
+			s += "      " + m.String() + " unrealized\n"
+			unrealized += m.Profit()
+		}
+	}
+
+	s += fmt.Sprintf("      total: realized %.2f, unrealized %.2f\n", realized, unrealized)
+
+	return s
+}
@@ -0,0 +1,135 @@
+package schema
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func day(d int) time.Time {
+	return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func lotDeal(d, quantity int, price, commission float64) Deal {
+	return Deal{
+		Date:       day(d),
+		Price:      NewCValue(price, "RUB"),
+		Quantity:   quantity,
+		Commission: commission,
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	tests := []struct {
+		name      string
+		costBasis CostBasis
+		picks     map[string][]string
+		deals     []Deal
+		lots      []Lot
+		realized  []LotMatch
+	}{
+		{
+			name:      "fifo",
+			costBasis: CostBasisFifo,
+			deals:     []Deal{lotDeal(1, 10, 100, 0), lotDeal(2, 10, 120, 0), lotDeal(3, -15, 130, 0)},
+			lots:      []Lot{{Date: day(2), Quantity: 5, Price: 120}},
+			realized: []LotMatch{
+				{Open: day(1), Close: day(3), Quantity: 10, Cost: 1000, Proceeds: 1300},
+				{Open: day(2), Close: day(3), Quantity: 5, Cost: 600, Proceeds: 650},
+			},
+		},
+		{
+			name:      "lifo",
+			costBasis: CostBasisLifo,
+			deals:     []Deal{lotDeal(1, 10, 100, 0), lotDeal(2, 10, 120, 0), lotDeal(3, -15, 130, 0)},
+			lots:      []Lot{{Date: day(1), Quantity: 5, Price: 100}},
+			realized: []LotMatch{
+				{Open: day(2), Close: day(3), Quantity: 10, Cost: 1200, Proceeds: 1300},
+				{Open: day(1), Close: day(3), Quantity: 5, Cost: 500, Proceeds: 650},
+			},
+		},
+		{
+			name:      "average",
+			costBasis: CostBasisAverage,
+			deals:     []Deal{lotDeal(1, 10, 100, 0), lotDeal(2, 10, 120, 0), lotDeal(3, -15, 130, 0)},
+			lots:      []Lot{{Date: day(1), Quantity: 5, Price: 110}},
+			realized: []LotMatch{
+				{Open: day(1), Close: day(3), Quantity: 15, Cost: 1650, Proceeds: 1950},
+			},
+		},
+		{
+			name:      "specific",
+			costBasis: CostBasisSpecific,
+			picks:     map[string][]string{"2021/01/04": {"2021/01/02"}},
+			deals: []Deal{lotDeal(1, 10, 100, 0), lotDeal(2, 10, 120, 0), lotDeal(3, 10, 90, 0),
+				lotDeal(4, -15, 130, 0)},
+			lots: []Lot{{Date: day(1), Quantity: 5, Price: 100}, {Date: day(3), Quantity: 10, Price: 90}},
+			realized: []LotMatch{
+				{Open: day(2), Close: day(4), Quantity: 10, Cost: 1200, Proceeds: 1300},
+				{Open: day(1), Close: day(4), Quantity: 5, Cost: 500, Proceeds: 650},
+			},
+		},
+		{
+			name:      "commissions",
+			costBasis: CostBasisFifo,
+			deals:     []Deal{lotDeal(1, 10, 100, -10), lotDeal(2, -4, 110, -4)},
+			lots:      []Lot{{Date: day(1), Quantity: 6, Price: 101, Commission: -1}},
+			realized: []LotMatch{
+				{Open: day(1), Close: day(2), Quantity: 4, Cost: 404, Proceeds: 436},
+			},
+		},
+		{
+			name:      "short",
+			costBasis: CostBasisFifo,
+			deals:     []Deal{lotDeal(1, -10, 100, 0), lotDeal(2, 4, 90, 0)},
+			lots:      []Lot{{Date: day(1), Quantity: -6, Price: 100}},
+			realized: []LotMatch{
+				{Open: day(1), Close: day(2), Quantity: -4, Cost: -400, Proceeds: -360},
+			},
+		},
+		{
+			name:      "flip",
+			costBasis: CostBasisFifo,
+			deals:     []Deal{lotDeal(1, 5, 100, 0), lotDeal(2, -8, 110, -8)},
+			lots:      []Lot{{Date: day(2), Quantity: -3, Price: 109, Commission: -1}},
+			realized: []LotMatch{
+				{Open: day(1), Close: day(2), Quantity: 5, Cost: 500, Proceeds: 545},
+			},
+		},
+	}
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+
+	for _, tt := range tests {
+		pinfo := PositionInfo{CostBasis: tt.costBasis, LotPicks: tt.picks}
+		for _, deal := range tt.deals {
+			pinfo.matchLots(deal)
+		}
+
+		if len(pinfo.Lots) != len(tt.lots) {
+			t.Errorf("%s: got %d lots, expected %d", tt.name, len(pinfo.Lots), len(tt.lots))
+		} else {
+			for i, lot := range tt.lots {
+				got := *pinfo.Lots[i]
+				if !got.Date.Equal(lot.Date) || got.Quantity != lot.Quantity ||
+					!near(got.Price, lot.Price) || !near(got.Commission, lot.Commission) {
+					t.Errorf("%s: lot %d is %v, expected %v", tt.name, i, got, lot)
+				}
+			}
+		}
+
+		if len(pinfo.Realized) != len(tt.realized) {
+			t.Errorf("%s: got %d matches, expected %d", tt.name, len(pinfo.Realized), len(tt.realized))
+			continue
+		}
+		for i, m := range tt.realized {
+			got := pinfo.Realized[i]
+			if !got.Open.Equal(m.Open) || !got.Close.Equal(m.Close) || got.Quantity != m.Quantity ||
+				!near(got.Cost, m.Cost) || !near(got.Proceeds, m.Proceeds) {
+				t.Errorf("%s: match %d is %v, expected %v", tt.name, i, got, m)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package schema
 
 import (
 	"fmt"
+	"strings"
 
 	"../aux"
 )
@@ -12,10 +13,85 @@ func (op Operation) StringPretty() string {
 		shortTick = "USD"
 	}
 
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"%s: %-17s %-4s (%-7.2f x %-3d) = %s %-9.2f",
 		op.DateParsed.Format("2006/01/02"), op.OperationType, shortTick, op.Price, op.Quantity(),
 		op.Currency, op.Payment)
+	if op.IsMarginCall {
+		s += " (margin call)"
+	}
+	if op.Note != "" || len(op.Tags) > 0 {
+		s += " #"
+		if op.Note != "" {
+			s += " " + op.Note
+		}
+		if len(op.Tags) > 0 {
+			s += " [" + strings.Join(op.Tags, ", ") + "]"
+		}
+	}
+	return s
+}
+
+type OperationKind int
+
+const (
+	OpUnknown          OperationKind = iota
+	OpTrade                          // Buy, Sell
+	OpTradeCard                      // Buy paid by card, money comes from outside
+	OpRepayment                      // bond is paid off, closes the position
+	OpSecurityIn                     // securities come from outside
+	OpSecurityOut                    // securities go outside
+	OpBrokerCommission               // included in deals
+	OpCommission
+	OpPayIn
+	OpPayOut
+	OpIncome      // dividends, coupons & partial repayments
+	OpPositionTax // taxes on income
+	OpTax
+	OpTaxBack
+)
+
+// every OperationTypeWithCommission from api/swagger.yaml
+var operationKinds = map[string]OperationKind{
+	"Buy":                OpTrade,
+	"BuyCard":            OpTradeCard,
+	"Sell":               OpTrade,
+	"BrokerCommission":   OpBrokerCommission,
+	"ExchangeCommission": OpCommission,
+	"ServiceCommission":  OpCommission,
+	"MarginCommission":   OpCommission,
+	"OtherCommission":    OpCommission,
+	"PayIn":              OpPayIn,
+	"PayOut":             OpPayOut,
+	"Tax":                OpTax,
+	"TaxLucre":           OpTax,
+	"TaxDividend":        OpPositionTax,
+	"TaxCoupon":          OpPositionTax,
+	"TaxBack":            OpTaxBack,
+	"Repayment":          OpRepayment,
+	"PartRepayment":      OpIncome,
+	"Coupon":             OpIncome,
+	"Dividend":           OpIncome,
+	"SecurityIn":         OpSecurityIn,
+	"SecurityOut":        OpSecurityOut,
+}
+
+func IsKnownOperation(typ string) bool {
+	_, ok := operationKinds[typ]
+	return ok
+}
+
+func (op Operation) Kind() OperationKind {
+	return operationKinds[op.OperationType]
+}
+
+func (op Operation) IsCommission() bool {
+	return op.Kind() == OpCommission || op.Kind() == OpBrokerCommission
+}
+
+// IsDeal tells if the operation changes the position quantity
+func (op Operation) IsDeal() bool {
+	return op.IsTrading() || aux.IsIn(op.OperationType, "Repayment", "SecurityIn", "SecurityOut")
 }
 
 func (op Operation) IsTrading() bool {
@@ -42,7 +118,11 @@ func (op Operation) Quantity() int {
 	for _, trade := range op.Trades {
 		quantity += int(trade.Quantity)
 	}
-	if op.OperationType == "Sell" {
+	if !op.IsTrading() && len(op.Trades) == 0 {
+		// transfers and repayments may come without trades
+		quantity = int(op.Quantity_)
+	}
+	if aux.IsIn(op.OperationType, "Sell", "SecurityOut", "Repayment") {
 		quantity = -quantity
 	}
 	return quantity
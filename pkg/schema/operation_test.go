@@ -0,0 +1,154 @@
+package schema
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOperationKind(t *testing.T) {
+	// every OperationTypeWithCommission from api/swagger.yaml
+	tests := []struct {
+		typ  string
+		kind OperationKind
+	}{
+		{"Buy", OpTrade},
+		{"BuyCard", OpTradeCard},
+		{"Sell", OpTrade},
+		{"BrokerCommission", OpBrokerCommission},
+		{"ExchangeCommission", OpCommission},
+		{"ServiceCommission", OpCommission},
+		{"MarginCommission", OpCommission},
+		{"OtherCommission", OpCommission},
+		{"PayIn", OpPayIn},
+		{"PayOut", OpPayOut},
+		{"Tax", OpTax},
+		{"TaxLucre", OpTax},
+		{"TaxDividend", OpPositionTax},
+		{"TaxCoupon", OpPositionTax},
+		{"TaxBack", OpTaxBack},
+		{"Repayment", OpRepayment},
+		{"PartRepayment", OpIncome},
+		{"Coupon", OpIncome},
+		{"Dividend", OpIncome},
+		{"SecurityIn", OpSecurityIn},
+		{"SecurityOut", OpSecurityOut},
+	}
+
+	for _, tt := range tests {
+		if got := (Operation{OperationType: tt.typ}).Kind(); got != tt.kind {
+			t.Errorf("%s: got kind %d, expected %d", tt.typ, got, tt.kind)
+		}
+		if !IsKnownOperation(tt.typ) {
+			t.Errorf("%s: unknown", tt.typ)
+		}
+	}
+
+	if IsKnownOperation("Transfer") || (Operation{OperationType: "Transfer"}).Kind() != OpUnknown {
+		t.Errorf("Transfer is known")
+	}
+}
+
+func TestOperationQuantity(t *testing.T) {
+	trades := []Trade{{Quantity: 3}, {Quantity: 4}}
+
+	tests := []struct {
+		name string
+		op   Operation
+		exp  int
+	}{
+		{
+			name: "buy, the trades",
+			op:   Operation{OperationType: "Buy", Quantity_: 10, Trades: trades},
+			exp:  7,
+		},
+		{
+			name: "sell, the trades",
+			op:   Operation{OperationType: "Sell", Quantity_: 10, Trades: trades},
+			exp:  -7,
+		},
+		{
+			name: "buy without trades",
+			op:   Operation{OperationType: "Buy", Quantity_: 10},
+			exp:  0,
+		},
+		{
+			name: "security in without trades",
+			op:   Operation{OperationType: "SecurityIn", Quantity_: 10},
+			exp:  10,
+		},
+		{
+			name: "security out without trades",
+			op:   Operation{OperationType: "SecurityOut", Quantity_: 10},
+			exp:  -10,
+		},
+		{
+			name: "repayment without trades",
+			op:   Operation{OperationType: "Repayment", Quantity_: 10},
+			exp:  -10,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.op.Quantity(); got != tt.exp {
+			t.Errorf("%s: got %d, expected %d", tt.name, got, tt.exp)
+		}
+	}
+}
+
+func TestBalancePayins(t *testing.T) {
+	xchgrate := func(curr_from, curr_to string, t time.Time) float64 {
+		if curr_from == "USD" && curr_to == "RUB" {
+			return 70
+		}
+		return 1
+	}
+
+	tests := []struct {
+		name     string
+		op       Operation
+		payin    float64 // in the operation currency
+		payinAll float64 // RUB
+	}{
+		{
+			name:     "security in",
+			op:       Operation{OperationType: "SecurityIn", Currency: "RUB", Price: 100, Quantity_: 10},
+			payin:    1000,
+			payinAll: 1000,
+		},
+		{
+			name:     "security out",
+			op:       Operation{OperationType: "SecurityOut", Currency: "USD", Price: 10, Quantity_: 5},
+			payin:    -50,
+			payinAll: -3500,
+		},
+		{
+			name: "card buy",
+			op: Operation{OperationType: "BuyCard", Currency: "RUB", Price: 100, Payment: -300,
+				Trades: []Trade{{Price: 100, Quantity: 3}}},
+			payin:    300,
+			payinAll: 300,
+		},
+		{
+			name: "transfer in",
+			op: Operation{OperationType: "SecurityIn", Currency: "RUB", Price: 100, Quantity_: 10,
+				IsTransfer: true},
+		},
+		{
+			name: "buy",
+			op: Operation{OperationType: "Buy", Currency: "RUB", Price: 100, Payment: -300,
+				Trades: []Trade{{Price: 100, Quantity: 3}}},
+		},
+	}
+
+	for _, tt := range tests {
+		bal := NewBalance()
+		bal.AddOperation(tt.op, xchgrate)
+
+		if got := bal.Payins[tt.op.Currency].Value; got != tt.payin {
+			t.Errorf("%s: got payin %.2f, expected %.2f", tt.name, got, tt.payin)
+		}
+		if got := bal.Payins["all"].Value; got != tt.payinAll {
+			t.Errorf("%s: got total payin %.2f, expected %.2f", tt.name, got, tt.payinAll)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package schema
 
 import (
 	"fmt"
+
+	"../aux"
 )
 
 type Portion struct {
@@ -9,6 +11,7 @@ type Portion struct {
 	Close Deal
 
 	IsClosed bool
+	IsShort  bool
 
 	Balance     CValue
 	Yield       float64
@@ -21,6 +24,50 @@ func (po *Portion) finalize(deal Deal, isClosed bool) {
 	po.IsClosed = isClosed
 }
 
+// long: buys are the expense, sells and the close are the value
+func (po Portion) longFlows(xirr *aux.XirrCtx) (value, expense, result float64) {
+	value = -po.Close.Value()
+	expense = -po.Close.Commission
+
+	for _, deal := range po.Buys {
+		if deal.IsBuy() {
+			expense += deal.Expense()
+		} else {
+			value += deal.Profit()
+		}
+
+		xirr.AddPayment(deal.Expense(), deal.Date)
+	}
+
+	return value, expense, po.Close.Profit()
+}
+
+// short: the proceeds of the short sells are at stake,
+// buying back returns them together with the profit
+func (po Portion) shortFlows(xirr *aux.XirrCtx) (value, expense, result float64) {
+	shorted := 0
+
+	back := func(deal Deal) float64 {
+		return 2*expense/float64(shorted)*float64(deal.Quantity) - deal.Expense()
+	}
+
+	for _, deal := range po.Buys {
+		if deal.IsBuy() {
+			value += back(deal)
+			xirr.AddPayment(-back(deal), deal.Date)
+		} else {
+			expense += deal.Profit()
+			shorted -= deal.Quantity
+			xirr.AddPayment(deal.Profit(), deal.Date)
+		}
+	}
+
+	result = back(po.Close)
+	value += result
+
+	return value, expense, result
+}
+
 func (po Portion) benchValue(benchPricef PriceAt) float64 {
 	var quantity float64
 
@@ -47,6 +94,9 @@ func (po Portion) String() string {
 	if po.IsClosed {
 		date = po.Close.Date.Format("2006/01/02")
 	}
+	if po.IsShort {
+		date += " short"
+	}
 
 	benchString := func(po Portion) string {
 		if po.YieldMarket == 0 { // TODO not right, might have been real 0
@@ -12,6 +12,11 @@ import (
 type Dividend struct {
 	Date  time.Time
 	Value float64
+	Type  string // Dividend, TaxDividend, Coupon etc
+}
+
+func (div Dividend) IsTax() bool {
+	return aux.IsIn(div.Type, "TaxDividend", "TaxCoupon")
 }
 
 type RepaymentPoint struct {
@@ -30,14 +35,41 @@ type PositionInfo struct {
 	OpenQuantity int
 	OpenDeal     Deal
 
+	CostBasis CostBasis
+	LotPicks  map[string][]string // sell date -> buy dates, for CostBasisSpecific
+	Lots      []*Lot
+	Realized  []LotMatch
+
 	// TODO commissions are counted here but not included in portion balances and yields
 	AccumulatedIncome CValue
 }
 
+// Copy can be finalized without touching the portions of pinfo
+func (pinfo PositionInfo) Copy() *PositionInfo {
+	portions := make([]*Portion, len(pinfo.Portions))
+	for i, po := range pinfo.Portions {
+		c := *po
+		portions[i] = &c
+	}
+	pinfo.Portions = portions
+	return &pinfo
+}
+
 func (pinfo PositionInfo) IsClosed() bool {
 	return pinfo.OpenDeal.Quantity == 0
 }
 
+// QuantityAt is the quantity held just before t
+func (pinfo PositionInfo) QuantityAt(t time.Time) int {
+	q := 0
+	for _, deal := range pinfo.Deals {
+		if deal.Date.Before(t) {
+			q += deal.Quantity
+		}
+	}
+	return q
+}
+
 func (pinfo PositionInfo) StringPretty() string {
 	s := fmt.Sprintf("%s:", pinfo.Ins.Name)
 
@@ -61,6 +93,8 @@ func (pinfo PositionInfo) StringPretty() string {
 		s += "      " + po.String() + "\n"
 	}
 
+	s += pinfo.lotsString()
+
 	return s
 }
 
@@ -79,25 +113,36 @@ func (pinfo PositionInfo) openPortion() *Portion {
 
 func (pinfo *PositionInfo) addDeal(deal Deal) {
 	pinfo.Deals = append(pinfo.Deals, deal)
+	pinfo.matchLots(deal)
+
+	if rest := pinfo.OpenQuantity + deal.Quantity; rest != 0 && pinfo.OpenQuantity != 0 &&
+		!sameSign(rest, pinfo.OpenQuantity) {
+		// the deal closes the position and opens the opposite one
+		closing, opening := deal.Split(-pinfo.OpenQuantity)
+		pinfo.addPortionDeal(closing)
+		pinfo.addPortionDeal(opening)
+		return
+	}
 
+	pinfo.addPortionDeal(deal)
+}
+
+func (pinfo *PositionInfo) addPortionDeal(deal Deal) {
 	po := pinfo.openPortion()
 	if po == nil {
 		po = &Portion{
 			Balance: NewCValue(0, deal.Price.Currency),
+			IsShort: deal.Quantity < 0,
 		}
 		pinfo.Portions = append(pinfo.Portions, po)
 	}
 
 	pinfo.OpenQuantity += deal.Quantity
 
-	if pinfo.OpenQuantity < 0 {
-		log.Fatalf("negative balance? %v", pinfo)
-	}
-
 	if len(po.Buys) > 0 {
 		last := po.Buys[len(po.Buys)-1]
 
-		if deal.Date.Before(last.Date.Add(12 * time.Hour)) {
+		if deal.Date.Before(last.Date.Add(12*time.Hour)) && sameSign(deal.Quantity, last.Quantity) {
 			po.Buys = po.Buys[:len(po.Buys)-1]
 
 			// merge deals
@@ -105,47 +150,70 @@ func (pinfo *PositionInfo) addDeal(deal Deal) {
 			deal.Quantity += last.Quantity
 			deal.Accrued += last.Accrued
 			deal.Commission += last.Commission
+			deal.MarginCall = deal.MarginCall || last.MarginCall
 
 			deal.Price.Value = (sval - deal.Accrued) / float64(deal.Quantity)
 		}
 	}
 
-	if pinfo.OpenQuantity > 0 {
+	if pinfo.OpenQuantity != 0 {
 		po.Buys = append(po.Buys, deal)
 	} else {
-		// complete sell
+		// complete sell (or buy back for a short)
 		po.finalize(deal, true)
 	}
 }
 
-func (pinfo *PositionInfo) AddOperation(op Operation) (Deal, bool) {
-	log.Debugf("%v", op)
-
-	if op.Status != "Done" {
-		return Deal{}, false
+func (pinfo *PositionInfo) makeDeal(op Operation) Deal {
+	deal := Deal{
+		Date:       op.DateParsed,
+		Price:      NewCValue(op.Price, op.Currency),
+		Quantity:   op.Quantity(),
+		Commission: op.Commission.Value,
+		MarginCall: op.IsMarginCall,
 	}
 
-	if op.IsTrading() {
-		deal := Deal{
-			Date:       op.DateParsed,
-			Price:      NewCValue(op.Price, op.Currency),
-			Quantity:   op.Quantity(),
-			Commission: op.Commission.Value,
+	switch op.Kind() {
+	case OpRepayment:
+		// sell everything at the repayment price
+		deal.Quantity = -pinfo.OpenQuantity
+		if deal.Quantity != 0 {
+			deal.Price.Value = op.Payment / float64(-deal.Quantity)
+		} else {
+			deal.Price.Value = 0
+			deal.Accrued = -op.Payment
 		}
 
+	case OpSecurityIn, OpSecurityOut:
+		// no money involved
+
+	default:
 		// op.Payment is negative for Buy
 		// deal.Quantity is positive for Buy
 		// deal.Price is always positive
 		// Commission is not included in Payment
 		deal.Accrued = -op.Payment - deal.Price.Value*float64(deal.Quantity)
+	}
 
-		pinfo.addDeal(deal)
+	return deal
+}
 
-		return deal, true
+func (pinfo *PositionInfo) AddOperation(op Operation) (Deal, bool) {
+	log.Debugf("%v", op)
 
-	} else if op.OperationType == "BrokerCommission" {
-		// negative
-		pinfo.AccumulatedIncome.Value += op.Payment
+	if op.Status != "Done" {
+		return Deal{}, false
+	}
+
+	if op.IsDeal() {
+		deal := pinfo.makeDeal(op)
+		if op.Kind() == OpRepayment && deal.Quantity == 0 {
+			// nothing left to repay, keep the money anyway
+			pinfo.AccumulatedIncome.Value += op.Payment
+		} else {
+			pinfo.addDeal(deal)
+		}
+		return deal, true
 
 	} else if op.IsPayment() {
 		// income - positive, taxes - negative
@@ -154,9 +222,10 @@ func (pinfo *PositionInfo) AddOperation(op Operation) (Deal, bool) {
 			Dividend{
 				Date:  op.DateParsed,
 				Value: op.Payment,
+				Type:  op.OperationType,
 			})
-	} else if op.OperationType == "Tax" {
-		// negative
+	} else if aux.IsIn(op.OperationType, "Tax", "TaxLucre", "TaxBack") || op.IsCommission() {
+		// negative, but tax back
 		pinfo.AccumulatedIncome.Value += op.Payment
 	} else {
 		log.Warnf("Unprocessed transaction %v", op)
@@ -185,40 +254,45 @@ func (pinfo *PositionInfo) MakeOpenDeal(date time.Time, pricef func() float64) (
 	return deal, true
 }
 
-func (pinfo *PositionInfo) Finalize(benchPricef PriceAt) {
-	for _, po := range pinfo.Portions {
-		var xirr aux.XirrCtx
-		value := -po.Close.Value()
-		expense := -po.Close.Commission
+// PortionFlows adds the payments of the portion, dividends included, to xirr,
+// and returns its value, expense and the result at the close
+func (pinfo PositionInfo) PortionFlows(po *Portion, xirr *aux.XirrCtx) (value, expense, result float64) {
+	if po.IsShort {
+		value, expense, result = po.shortFlows(xirr)
+	} else {
+		value, expense, result = po.longFlows(xirr)
+	}
 
-		for _, div := range pinfo.Dividends {
-			if div.Date.Before(po.Buys[0].Date) {
-				continue
-			}
-			if div.Date.After(po.Close.Date) {
-				// TODO not quite right. Dividends come with delay
-				continue
-			}
-			value += div.Value
-			xirr.AddPayment(-div.Value, div.Date)
+	for _, div := range pinfo.Dividends {
+		if div.Date.Before(po.Buys[0].Date) {
+			continue
 		}
+		if div.Date.After(po.Close.Date) {
+			// TODO not quite right. Dividends come with delay
+			continue
+		}
+		value += div.Value
+		xirr.AddPayment(-div.Value, div.Date)
+	}
 
-		for _, deal := range po.Buys {
-			if deal.IsBuy() {
-				expense += deal.Expense()
-			} else {
-				value += deal.Profit()
-			}
+	return
+}
 
-			xirr.AddPayment(deal.Expense(), deal.Date)
-		}
+func (pinfo *PositionInfo) Finalize(benchPricef PriceAt) {
+	for _, po := range pinfo.Portions {
+		var xirr aux.XirrCtx
+		value, expense, result := pinfo.PortionFlows(po, &xirr)
 
 		// there are fictive deals with 0 quantity
 		if expense != 0 {
 			po.Yield = aux.Ratio2Perc(value / expense)
-			po.YieldAnnual = xirr.Ratio(po.Close.Profit(), po.Close.Date) * 100
+			rate, err := xirr.Ratio(result, po.Close.Date)
+			if err != nil {
+				log.Warnf("%s: %s", pinfo.Ins.Ticker, err)
+			}
+			po.YieldAnnual = rate * 100
 			// compare with the market ETF
-			if benchPricef != nil {
+			if benchPricef != nil && !po.IsShort {
 				po.YieldMarket = aux.Ratio2Perc(po.benchValue(benchPricef) / expense)
 			}
 		}
@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPositionCopy(t *testing.T) {
+	pinfo := &PositionInfo{}
+	pinfo.addDeal(lotDeal(1, 10, 100, 0))
+
+	cp := pinfo.Copy()
+	if _, ok := cp.MakeOpenDeal(day(2), func() float64 { return 120 }); !ok {
+		t.Fatal("no open deal")
+	}
+	cp.Finalize(nil)
+
+	po := pinfo.Portions[0]
+	if po.Close.Quantity != 0 || po.Balance.Value != 0 || !pinfo.OpenDeal.Date.IsZero() {
+		t.Errorf("the original is finalized: %v", po)
+	}
+	if cp.Portions[0].Balance.Value != 200 {
+		t.Errorf("the copy is not finalized: %v", cp.Portions[0])
+	}
+}
+
+func TestPositionShort(t *testing.T) {
+	type portion struct {
+		isShort, isClosed bool
+		quantity          int     // of the buys, sells negative
+		commission        float64 // of the buys and the close
+	}
+
+	tests := []struct {
+		name     string
+		deals    []Deal
+		open     int
+		portions []portion
+	}{
+		{
+			name:     "open",
+			deals:    []Deal{lotDeal(1, -10, 100, -1)},
+			open:     -10,
+			portions: []portion{{isShort: true, quantity: -10, commission: -1}},
+		},
+		{
+			name:     "partial cover",
+			deals:    []Deal{lotDeal(1, -10, 100, -1), lotDeal(2, 4, 90, -0.4)},
+			open:     -6,
+			portions: []portion{{isShort: true, quantity: -6, commission: -1.4}},
+		},
+		{
+			name:     "full cover",
+			deals:    []Deal{lotDeal(1, -10, 100, -1), lotDeal(2, 10, 90, -0.9)},
+			open:     0,
+			portions: []portion{{isShort: true, isClosed: true, quantity: -10, commission: -1.9}},
+		},
+		{
+			name:  "long to short",
+			deals: []Deal{lotDeal(1, 10, 100, 0), lotDeal(2, -15, 120, -3)},
+			open:  -5,
+			portions: []portion{
+				{isClosed: true, quantity: 10, commission: -2},
+				{isShort: true, quantity: -5, commission: -1},
+			},
+		},
+		{
+			name:  "short to long",
+			deals: []Deal{lotDeal(1, -10, 100, 0), lotDeal(2, 12, 90, -1.2)},
+			open:  2,
+			portions: []portion{
+				{isShort: true, isClosed: true, quantity: -10, commission: -1},
+				{quantity: 2, commission: -0.2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		pinfo := &PositionInfo{}
+		for _, deal := range tt.deals {
+			pinfo.addDeal(deal)
+		}
+
+		if pinfo.OpenQuantity != tt.open {
+			t.Errorf("%s: got %d open, expected %d", tt.name, pinfo.OpenQuantity, tt.open)
+		}
+		if len(pinfo.Portions) != len(tt.portions) {
+			t.Errorf("%s: got %d portions, expected %d", tt.name, len(pinfo.Portions), len(tt.portions))
+			continue
+		}
+		for i, exp := range tt.portions {
+			po := pinfo.Portions[i]
+			got := portion{isShort: po.IsShort, isClosed: po.IsClosed}
+			for _, deal := range po.Buys {
+				got.quantity += deal.Quantity
+				got.commission += deal.Commission
+			}
+			if po.IsClosed {
+				got.commission += po.Close.Commission
+			}
+			got.commission = math.Round(got.commission*100) / 100
+			if got != exp {
+				t.Errorf("%s: portion %d is %+v, expected %+v", tt.name, i, got, exp)
+			}
+		}
+	}
+}
+
+func TestPositionShortYield(t *testing.T) {
+	pinfo := &PositionInfo{}
+	// 1000 shorted, bought back for 800
+	pinfo.addDeal(lotDeal(1, -10, 100, 0))
+	pinfo.addDeal(lotDeal(2, 10, 80, 0))
+	pinfo.Finalize(nil)
+
+	po := pinfo.Portions[0]
+	if math.Abs(po.Yield-20) > 1e-9 {
+		t.Errorf("got %.2f%% yield, expected 20%%", po.Yield)
+	}
+	if math.Abs(po.Balance.Value-200) > 1e-9 {
+		t.Errorf("got %.2f balance, expected 200", po.Balance.Value)
+	}
+}
@@ -53,6 +53,10 @@ type Operation struct {
 	// Added fields below
 	DateParsed time.Time `json:"-"`
 	Ticker     string    `json:"-"`
+	Account    string    `json:"-"`
+	IsTransfer bool      `json:"-"` // between our own accounts
+	Note       string    `json:"note,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
 }
 
 type OperationsResponse struct {